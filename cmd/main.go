@@ -2,34 +2,75 @@ package main
 
 import (
 	"context"
+	"flag"
+	"io"
 	"log"
 	"os"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/milkymilky0116/jellyfish/internal/db"
 	"github.com/milkymilky0116/jellyfish/internal/mails"
-	"github.com/milkymilky0116/jellyfish/internal/repository"
+	"github.com/milkymilky0116/jellyfish/internal/tui"
 )
 
 func main() {
+	tracePath := flag.String("trace", "", "write the IMAP protocol trace to this file")
+	traceMaxLiteral := flag.Int("trace-max-literal", 0, "truncate traced literals longer than this many bytes (0 keeps them whole)")
+	recordPath := flag.String("record", "", "record a transcript of the IMAP session to this file, with credentials redacted")
+	dbPath := flag.String("db", "", "path of the cache database (default $JELLYFISH_DB or $XDG_DATA_HOME/jellyfish/jellyfish.db)")
+	flag.Parse()
+
+	var tracer *mails.Tracer
+	if *tracePath != "" {
+		traceFile, err := os.OpenFile(*tracePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer traceFile.Close()
+		tracer = mails.NewTracer(traceFile, *traceMaxLiteral)
+	}
+
+	var transcript io.Writer
+	if *recordPath != "" {
+		transcriptFile, err := os.OpenFile(*recordPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer transcriptFile.Close()
+		transcript = transcriptFile
+	}
+
 	server := os.Getenv("IMAP_URL")
 	ctx := context.Background()
-	db, err := db.InitSqliteDB(ctx)
+	if *dbPath == "" {
+		path, err := db.DefaultPath()
+		if err != nil {
+			log.Fatal(err)
+		}
+		*dbPath = path
+	}
+	sqlDB, err := db.InitSqliteDB(ctx, *dbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	repo := repository.New(db)
-	client, err := mails.InitMailClient(server, repo)
+	repo := db.NewRepository(sqlDB)
+	client, err := mails.InitMailClient(ctx, server, repo, tracer, transcript)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Conn.Close()
-	// model, err := tui.InitModel(client)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// tui := tea.NewProgram(model)
-	// if _, err := tui.Run(); err != nil {
-	// 	log.Fatalf("Fail to run tui: %v", err)
-	// }
-
+	defer client.Close()
+	err = client.SelectMailBox(ctx, "INBOX")
+	if err != nil {
+		log.Fatal(err)
+	}
+	idleCtx, stopIdle := context.WithCancel(ctx)
+	defer stopIdle()
+	model, err := tui.InitModel(ctx, client, client.Idle(idleCtx))
+	if err != nil {
+		log.Fatal(err)
+	}
+	tui := tea.NewProgram(model)
+	if _, err := tui.Run(); err != nil {
+		log.Fatalf("Fail to run tui: %v", err)
+	}
 }
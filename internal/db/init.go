@@ -3,14 +3,48 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/milkymilky0116/jellyfish/migrations"
 )
 
-func InitSqliteDB(ctx context.Context) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "test.db")
+// DefaultPath returns the database location: JELLYFISH_DB when set,
+// otherwise jellyfish/jellyfish.db under $XDG_DATA_HOME or ~/.local/share.
+func DefaultPath() (string, error) {
+	if path := os.Getenv("JELLYFISH_DB"); path != "" {
+		return path, nil
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.New("cannot find a data directory, set JELLYFISH_DB or XDG_DATA_HOME")
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "jellyfish", "jellyfish.db"), nil
+}
+
+// InitSqliteDB opens the database at path with WAL and foreign keys enabled
+// and applies any pending migrations.
+func InitSqliteDB(ctx context.Context, path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_foreign_keys=on&_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := Migrate(ctx, db, migrations.FS); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return db, nil
 }
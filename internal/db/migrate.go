@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations are tracked in goose's own version table, so a database that
+// was migrated with the goose CLI is picked up where it left off.
+const createVersionTable = `CREATE TABLE IF NOT EXISTS goose_db_version (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  version_id INTEGER NOT NULL,
+  is_applied INTEGER NOT NULL,
+  tstamp TIMESTAMP DEFAULT (datetime('now'))
+)`
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+}
+
+func loadMigrations(migrations fs.FS) ([]migration, error) {
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	loaded := []migration{}
+	for _, file := range files {
+		prefix, _, ok := strings.Cut(path.Base(file), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s has no version prefix", file)
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version prefix", file)
+		}
+		content, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			return nil, err
+		}
+		up, err := upSection(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", file, err)
+		}
+		loaded = append(loaded, migration{version: version, name: file, up: up})
+	}
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].version < loaded[j].version
+	})
+	return loaded, nil
+}
+
+func upSection(content string) (string, error) {
+	_, rest, ok := strings.Cut(content, "-- +goose Up")
+	if !ok {
+		return "", fmt.Errorf("missing -- +goose Up")
+	}
+	up, _, _ := strings.Cut(rest, "-- +goose Down")
+	return strings.TrimSpace(up), nil
+}
+
+func currentVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(version_id) FROM goose_db_version WHERE is_applied = 1").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version.Int64, nil
+}
+
+// Migrate applies every migration newer than the database, each in its own
+// transaction. A database migrated by a newer release is refused rather
+// than used with a schema this build does not know.
+func Migrate(ctx context.Context, db *sql.DB, migrations fs.FS) error {
+	loaded, err := loadMigrations(migrations)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, createVersionTable); err != nil {
+		return err
+	}
+	current, err := currentVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(loaded) > 0 && current > loaded[len(loaded)-1].version {
+		return fmt.Errorf("database schema version %d is newer than the latest supported version %d, please upgrade", current, loaded[len(loaded)-1].version)
+	}
+	for _, m := range loaded {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.up); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO goose_db_version (version_id, is_applied) VALUES (?, 1)", m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
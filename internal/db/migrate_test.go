@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/milkymilky0116/jellyfish/migrations"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func appliedVersions(t *testing.T, db *sql.DB) []int64 {
+	t.Helper()
+	rows, err := db.Query("SELECT version_id FROM goose_db_version WHERE is_applied = 1 ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	versions := []int64{}
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return versions
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	loaded, err := loadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+
+	if err := Migrate(ctx, db, migrations.FS); err != nil {
+		t.Fatal(err)
+	}
+	versions := appliedVersions(t, db)
+	if len(versions) != len(loaded) {
+		t.Fatalf("applied %d migrations, want %d", len(versions), len(loaded))
+	}
+	for i, m := range loaded {
+		if versions[i] != m.version {
+			t.Errorf("migration %d applied as version %d, want %d", i, versions[i], m.version)
+		}
+	}
+	for _, table := range []string{"email", "category", "email_body"} {
+		var name string
+		if err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name); err != nil {
+			t.Errorf("table %s: %v", table, err)
+		}
+	}
+
+	// Running again applies nothing.
+	if err := Migrate(ctx, db, migrations.FS); err != nil {
+		t.Fatal(err)
+	}
+	if again := appliedVersions(t, db); len(again) != len(versions) {
+		t.Errorf("second run applied %v", again[len(versions):])
+	}
+}
+
+func TestMigratePending(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	first := fstest.MapFS{
+		"1_create_a.sql": {Data: []byte("-- +goose Up\nCREATE TABLE a (id INTEGER);\n-- +goose Down\nDROP TABLE a;\n")},
+	}
+	if err := Migrate(ctx, db, first); err != nil {
+		t.Fatal(err)
+	}
+	second := fstest.MapFS{
+		"1_create_a.sql": first["1_create_a.sql"],
+		"2_create_b.sql": {Data: []byte("-- +goose Up\nCREATE TABLE b (id INTEGER);\n")},
+		"3_broken.sql":   {Data: []byte("-- +goose Up\nCREATE TABLE c (id INTEGER);\nCREATE TABLE nope (;\n")},
+	}
+	err := Migrate(ctx, db, second)
+	if err == nil || !strings.Contains(err.Error(), "3_broken.sql") {
+		t.Fatalf("err = %v, want 3_broken.sql to fail", err)
+	}
+	if got := appliedVersions(t, db); len(got) != 2 || got[1] != 2 {
+		t.Errorf("applied versions = %v, want [1 2]", got)
+	}
+	// The failed migration is rolled back as a whole.
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'c'").Scan(&count); err != nil || count != 0 {
+		t.Errorf("table c left behind: %d, %v", count, err)
+	}
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitSqliteDB(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := loaded[len(loaded)-1].version
+	if _, err := db.Exec("INSERT INTO goose_db_version (version_id, is_applied) VALUES (?, 1)", latest+1); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Migrate(ctx, db, migrations.FS)
+	if err == nil || !strings.Contains(err.Error(), "newer than the latest supported version") {
+		t.Errorf("err = %v, want the newer schema to be refused", err)
+	}
+	db.Close()
+	if db, err := InitSqliteDB(ctx, path); err == nil {
+		db.Close()
+		t.Error("InitSqliteDB opened a database from a newer release")
+	}
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+// Repository is the IRepository backed by a database handle. Each call
+// autocommits unless it is made through WithTx.
+type Repository struct {
+	*repository.Queries
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{Queries: repository.New(db), db: db}
+}
+
+// WithTx runs fn with a repository bound to one transaction, committing when
+// fn returns nil and rolling back otherwise.
+func (r *Repository) WithTx(ctx context.Context, fn func(IRepository) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(&txRepository{Queries: r.Queries.WithTx(tx)}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type txRepository struct {
+	*repository.Queries
+}
+
+// WithTx on a repository that is already in a transaction joins it.
+func (t *txRepository) WithTx(ctx context.Context, fn func(IRepository) error) error {
+	return fn(t)
+}
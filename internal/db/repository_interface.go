@@ -12,4 +12,24 @@ type IRepository interface {
 	CreateCategory(context.Context, repository.CreateCategoryParams) (repository.Category, error)
 	GetCategory(context.Context, string) (repository.Category, error)
 	RegisterEmailAndCategory(context.Context, repository.RegisterEmailAndCategoryParams) error
+	UpdateCategorySyncState(context.Context, repository.UpdateCategorySyncStateParams) error
+	GetEmailByUID(context.Context, repository.GetEmailByUIDParams) (repository.Email, error)
+	ListEmailsByCategory(context.Context, int64) ([]repository.Email, error)
+	UpdateEmail(context.Context, repository.UpdateEmailParams) (repository.Email, error)
+	ListEmailUIDsByCategory(context.Context, int64) ([]int64, error)
+	DeleteEmailByUID(context.Context, repository.DeleteEmailByUIDParams) error
+	PurgeStaleEmails(context.Context, repository.PurgeStaleEmailsParams) error
+	GetEmailBody(context.Context, int64) (repository.EmailBody, error)
+	SaveEmailBody(context.Context, repository.SaveEmailBodyParams) error
+	UpdateEmailFlags(context.Context, repository.UpdateEmailFlagsParams) error
+	ListCategories(context.Context) ([]repository.Category, error)
+	RenameCategory(context.Context, repository.RenameCategoryParams) error
+	DeleteCategory(context.Context, int64) error
+	DeleteCategoryEmails(context.Context, int64) error
+	SetCategorySubscribed(context.Context, repository.SetCategorySubscribedParams) error
+	MoveEmailToCategory(context.Context, repository.MoveEmailToCategoryParams) error
+	UpdateEmailUID(context.Context, repository.UpdateEmailUIDParams) error
+	UpdateCategoryAttributes(context.Context, repository.UpdateCategoryAttributesParams) error
+	UpdateCategoryStatus(context.Context, repository.UpdateCategoryStatusParams) error
+	WithTx(context.Context, func(IRepository) error) error
 }
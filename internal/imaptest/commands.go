@@ -0,0 +1,656 @@
+package imaptest
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func (c *session) selectMailBox(name string, args []arg) ([]string, string) {
+	if len(args) == 0 {
+		return nil, "BAD " + name + " expects a mailbox"
+	}
+	c.selected = nil
+	mailbox := c.server.mailbox(args[0].value)
+	if mailbox == nil || hasFlag(mailbox.Attributes, `\Noselect`) {
+		return nil, "NO [NONEXISTENT] No such mailbox"
+	}
+	var qresync []arg
+	for _, param := range args[1:] {
+		for i := 0; i < len(param.list); i++ {
+			switch strings.ToUpper(param.list[i].value) {
+			case "CONDSTORE":
+				if !c.server.has("CONDSTORE") {
+					return nil, "BAD CONDSTORE not supported"
+				}
+				c.enabled["CONDSTORE"] = true
+			case "QRESYNC":
+				if !c.enabled["QRESYNC"] || i+1 >= len(param.list) {
+					return nil, "BAD QRESYNC is not enabled"
+				}
+				i++
+				qresync = param.list[i].list
+			}
+		}
+	}
+	c.selected = mailbox
+	c.readOnly = name == "EXAMINE"
+	c.uids = c.uids[:0]
+	c.modseqs = map[uint32]int64{}
+	for _, msg := range mailbox.Messages {
+		c.uids = append(c.uids, msg.UID)
+		c.modseqs[msg.UID] = msg.ModSeq
+	}
+	lines := []string{
+		`* FLAGS (\Answered \Flagged \Deleted \Seen \Draft)`,
+		`* OK [PERMANENTFLAGS (\Answered \Flagged \Deleted \Seen \Draft \*)] Flags permitted`,
+		fmt.Sprintf("* %d EXISTS", len(mailbox.Messages)),
+		"* 0 RECENT",
+		fmt.Sprintf("* OK [UIDVALIDITY %d] UIDs valid", mailbox.UIDValidity),
+		fmt.Sprintf("* OK [UIDNEXT %d] Predicted next UID", mailbox.UIDNext),
+	}
+	if c.server.has("CONDSTORE") {
+		lines = append(lines, fmt.Sprintf("* OK [HIGHESTMODSEQ %d] Highest", mailbox.HighestModSeq))
+	}
+	if len(qresync) >= 2 {
+		lines = append(lines, c.resync(qresync)...)
+	}
+	access := "[READ-WRITE]"
+	if c.readOnly {
+		access = "[READ-ONLY]"
+	}
+	return lines, "OK " + access + " " + name + " completed"
+}
+
+// resync answers the QRESYNC select parameter (uidvalidity modseq
+// [known-uids]) with the messages expunged and changed since modseq.
+func (c *session) resync(params []arg) []string {
+	uidValidity, err := strconv.ParseUint(params[0].value, 10, 32)
+	if err != nil || uint32(uidValidity) != c.selected.UIDValidity {
+		return nil
+	}
+	modseq, err := strconv.ParseInt(params[1].value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	vanished := []uint32{}
+	if len(params) > 2 {
+		known, err := parseSet(params[2].value, c.selected.UIDNext-1)
+		if err != nil {
+			return nil
+		}
+		for _, uid := range known {
+			if c.selected.message(uid) == nil {
+				vanished = append(vanished, uid)
+			}
+		}
+	} else {
+		for uid, expungedAt := range c.selected.expunged {
+			if expungedAt > modseq {
+				vanished = append(vanished, uid)
+			}
+		}
+	}
+	lines := []string{}
+	if len(vanished) > 0 {
+		lines = append(lines, "* VANISHED (EARLIER) "+formatSet(vanished))
+	}
+	for i, msg := range c.selected.Messages {
+		if msg.ModSeq > modseq {
+			lines = append(lines, fmt.Sprintf("* %d FETCH (%s)", i+1, c.flagItems(msg)))
+		}
+	}
+	return lines
+}
+
+// list answers LIST and LSUB, including the LIST-EXTENDED SUBSCRIBED
+// selection option and the LIST-STATUS return option.
+func (c *session) list(name string, args []arg) ([]string, string) {
+	subscribedOnly := name == "LSUB"
+	if len(args) > 0 && args[0].kind == listArg {
+		for _, option := range args[0].list {
+			if strings.EqualFold(option.value, "SUBSCRIBED") {
+				subscribedOnly = true
+			}
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return nil, "BAD " + name + " expects a reference and a pattern"
+	}
+	patterns := []string{args[1].value}
+	if args[1].kind == listArg {
+		patterns = patterns[:0]
+		for _, pattern := range args[1].list {
+			patterns = append(patterns, pattern.value)
+		}
+	}
+	var statusItems []arg
+	if len(args) > 3 && strings.EqualFold(args[2].value, "RETURN") {
+		for i := 0; i+1 < len(args[3].list); i++ {
+			if strings.EqualFold(args[3].list[i].value, "STATUS") {
+				if !c.server.has("LIST-STATUS") {
+					return nil, "BAD LIST-STATUS not supported"
+				}
+				statusItems = args[3].list[i+1].list
+			}
+		}
+	}
+	lines := []string{}
+	for _, mailboxName := range c.sortedMailBoxes() {
+		mailbox := c.server.mailboxes[mailboxName]
+		if subscribedOnly && !mailbox.Subscribed {
+			continue
+		}
+		if !slices.ContainsFunc(patterns, func(pattern string) bool {
+			return matchPattern(args[0].value+pattern, mailboxName, c.server.delimiter)
+		}) {
+			continue
+		}
+		attributes := slices.Clone(mailbox.Attributes)
+		if c.hasChildren(mailboxName) {
+			attributes = append(attributes, `\HasChildren`)
+		} else {
+			attributes = append(attributes, `\HasNoChildren`)
+		}
+		if subscribedOnly && name == "LIST" {
+			attributes = append(attributes, `\Subscribed`)
+		}
+		lines = append(lines, fmt.Sprintf("* %s (%s) %s %s", name, strings.Join(attributes, " "), quote(c.server.delimiter), quote(mailboxName)))
+		if statusItems != nil && !hasFlag(mailbox.Attributes, `\Noselect`) {
+			status, err := c.statusItems(mailbox, statusItems)
+			if err != nil {
+				return nil, "BAD " + err.Error()
+			}
+			lines = append(lines, status)
+		}
+	}
+	return lines, "OK " + name + " completed"
+}
+
+func (c *session) sortedMailBoxes() []string {
+	names := []string{}
+	for name := range c.server.mailboxes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (c *session) hasChildren(name string) bool {
+	for other := range c.server.mailboxes {
+		if strings.HasPrefix(other, name+c.server.delimiter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *session) status(args []arg) ([]string, string) {
+	if len(args) < 2 || args[1].kind != listArg {
+		return nil, "BAD STATUS expects a mailbox and a list of items"
+	}
+	mailbox := c.server.mailbox(args[0].value)
+	if mailbox == nil {
+		return nil, "NO [NONEXISTENT] No such mailbox"
+	}
+	status, err := c.statusItems(mailbox, args[1].list)
+	if err != nil {
+		return nil, "BAD " + err.Error()
+	}
+	return []string{status}, "OK STATUS completed"
+}
+
+func (c *session) statusItems(mailbox *MailBox, items []arg) (string, error) {
+	values := []string{}
+	for _, item := range items {
+		name := strings.ToUpper(item.value)
+		var value string
+		switch name {
+		case "MESSAGES":
+			value = strconv.Itoa(len(mailbox.Messages))
+		case "UNSEEN":
+			value = strconv.Itoa(mailbox.unseen())
+		case "UIDNEXT":
+			value = strconv.FormatUint(uint64(mailbox.UIDNext), 10)
+		case "UIDVALIDITY":
+			value = strconv.FormatUint(uint64(mailbox.UIDValidity), 10)
+		case "RECENT":
+			value = "0"
+		case "HIGHESTMODSEQ":
+			if !c.server.has("CONDSTORE") {
+				return "", fmt.Errorf("CONDSTORE not supported")
+			}
+			value = strconv.FormatInt(mailbox.HighestModSeq, 10)
+		default:
+			return "", fmt.Errorf("unknown STATUS item %s", name)
+		}
+		values = append(values, name+" "+value)
+	}
+	return fmt.Sprintf("* STATUS %s (%s)", quote(mailbox.Name), strings.Join(values, " ")), nil
+}
+
+func (c *session) create(args []arg) ([]string, string) {
+	if len(args) == 0 {
+		return nil, "BAD CREATE expects a mailbox"
+	}
+	name := strings.TrimSuffix(args[0].value, c.server.delimiter)
+	if c.server.mailbox(name) != nil {
+		return nil, "NO [ALREADYEXISTS] Mailbox already exists"
+	}
+	c.server.createMailBox(name)
+	c.server.changed()
+	return nil, "OK CREATE completed"
+}
+
+func (c *session) rename(args []arg) ([]string, string) {
+	if len(args) < 2 {
+		return nil, "BAD RENAME expects two mailboxes"
+	}
+	from, to := args[0].value, args[1].value
+	if strings.EqualFold(from, "INBOX") {
+		return nil, "NO [CANNOT] Renaming INBOX is not supported"
+	}
+	mailbox := c.server.mailbox(from)
+	if mailbox == nil {
+		return nil, "NO [NONEXISTENT] No such mailbox"
+	}
+	if c.server.mailbox(to) != nil {
+		return nil, "NO [ALREADYEXISTS] Mailbox already exists"
+	}
+	for _, name := range c.sortedMailBoxes() {
+		if name != from && !strings.HasPrefix(name, from+c.server.delimiter) {
+			continue
+		}
+		renamed := c.server.mailboxes[name]
+		delete(c.server.mailboxes, name)
+		renamed.Name = to + strings.TrimPrefix(name, from)
+		c.server.mailboxes[renamed.Name] = renamed
+	}
+	c.server.changed()
+	return nil, "OK RENAME completed"
+}
+
+func (c *session) delete(args []arg) ([]string, string) {
+	if len(args) == 0 {
+		return nil, "BAD DELETE expects a mailbox"
+	}
+	if strings.EqualFold(args[0].value, "INBOX") {
+		return nil, "NO [CANNOT] INBOX cannot be deleted"
+	}
+	mailbox := c.server.mailbox(args[0].value)
+	if mailbox == nil {
+		return nil, "NO [NONEXISTENT] No such mailbox"
+	}
+	delete(c.server.mailboxes, mailbox.Name)
+	if c.selected == mailbox {
+		c.selected = nil
+	}
+	c.server.changed()
+	return nil, "OK DELETE completed"
+}
+
+func (c *session) subscribe(subscribe bool, args []arg) ([]string, string) {
+	if len(args) == 0 {
+		return nil, "BAD SUBSCRIBE expects a mailbox"
+	}
+	mailbox := c.server.mailbox(args[0].value)
+	if mailbox == nil {
+		return nil, "NO [NONEXISTENT] No such mailbox"
+	}
+	mailbox.Subscribed = subscribe
+	return nil, "OK completed"
+}
+
+// messages resolves a sequence or UID set against the messages this session
+// knows about, in mailbox order.
+func (c *session) messages(uid bool, set string) ([]*Message, error) {
+	largest := uint32(len(c.uids))
+	if uid && len(c.uids) > 0 {
+		largest = c.uids[len(c.uids)-1]
+	}
+	nums, err := parseSet(set, largest)
+	if err != nil {
+		return nil, err
+	}
+	messages := []*Message{}
+	for i, known := range c.uids {
+		match := uint32(i + 1)
+		if uid {
+			match = known
+		}
+		if !slices.Contains(nums, match) {
+			continue
+		}
+		if msg := c.selected.message(known); msg != nil {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (c *session) fetch(uid bool, args []arg) ([]string, string) {
+	if len(args) < 2 {
+		return nil, "BAD FETCH expects a set and items"
+	}
+	lines := c.updates(false)
+	messages, err := c.messages(uid, args[0].value)
+	if err != nil {
+		return nil, "BAD " + err.Error()
+	}
+	items := fetchItemNames(args[1])
+	if uid && !slices.Contains(items, "UID") {
+		items = append([]string{"UID"}, items...)
+	}
+	changedSince := int64(-1)
+	if len(args) > 2 && len(args[2].list) == 2 && strings.EqualFold(args[2].list[0].value, "CHANGEDSINCE") {
+		if !c.server.has("CONDSTORE") {
+			return nil, "BAD CONDSTORE not supported"
+		}
+		changedSince, err = strconv.ParseInt(args[2].list[1].value, 10, 64)
+		if err != nil {
+			return nil, "BAD Invalid CHANGEDSINCE"
+		}
+		if !slices.Contains(items, "MODSEQ") {
+			items = append(items, "MODSEQ")
+		}
+	}
+	for _, msg := range messages {
+		if msg.ModSeq <= changedSince {
+			continue
+		}
+		values := []string{}
+		for _, item := range items {
+			value, err := c.fetchItem(msg, item)
+			if err != nil {
+				return nil, "BAD " + err.Error()
+			}
+			values = append(values, value)
+		}
+		lines = append(lines, fmt.Sprintf("* %d FETCH (%s)", c.seq(msg.UID), strings.Join(values, " ")))
+	}
+	return lines, "OK FETCH completed"
+}
+
+func (c *session) fetchItem(msg *Message, item string) (string, error) {
+	upper := strings.ToUpper(item)
+	switch upper {
+	case "UID":
+		return fmt.Sprintf("UID %d", msg.UID), nil
+	case "FLAGS":
+		return fmt.Sprintf("FLAGS (%s)", strings.Join(msg.Flags, " ")), nil
+	case "INTERNALDATE":
+		return "INTERNALDATE " + quote(msg.InternalDate.Format("02-Jan-2006 15:04:05 -0700")), nil
+	case "RFC822.SIZE":
+		return fmt.Sprintf("RFC822.SIZE %d", len(msg.Raw)), nil
+	case "ENVELOPE":
+		return "ENVELOPE " + parseEntity(msg.Raw).envelope(), nil
+	case "BODYSTRUCTURE":
+		return "BODYSTRUCTURE " + parseEntity(msg.Raw).structure(true), nil
+	case "BODY":
+		return "BODY " + parseEntity(msg.Raw).structure(false), nil
+	case "MODSEQ":
+		if !c.server.has("CONDSTORE") {
+			return "", fmt.Errorf("CONDSTORE not supported")
+		}
+		return fmt.Sprintf("MODSEQ (%d)", msg.ModSeq), nil
+	case "RFC822", "RFC822.HEADER", "RFC822.TEXT":
+		section := strings.TrimPrefix(strings.TrimPrefix(upper, "RFC822"), ".")
+		content, _ := parseEntity(msg.Raw).section(section)
+		return upper + " " + quote(content), nil
+	}
+	spec, peek := strings.CutPrefix(upper, "BODY.PEEK[")
+	if !peek {
+		var ok bool
+		if spec, ok = strings.CutPrefix(upper, "BODY["); !ok {
+			return "", fmt.Errorf("unknown FETCH item %s", item)
+		}
+	}
+	if !strings.HasSuffix(spec, "]") {
+		return "", fmt.Errorf("unsupported FETCH item %s", item)
+	}
+	spec = item[len(item)-len(spec) : len(item)-1]
+	content, ok := parseEntity(msg.Raw).section(spec)
+	if !ok {
+		return "", fmt.Errorf("invalid section %s", spec)
+	}
+	if !peek && !c.readOnly && !hasFlag(msg.Flags, `\Seen`) {
+		msg.Flags = append(msg.Flags, `\Seen`)
+		c.server.touch(c.selected, msg)
+		c.modseqs[msg.UID] = msg.ModSeq
+		c.server.changed()
+		return fmt.Sprintf("BODY[%s] %s FLAGS (%s)", spec, quote(content), strings.Join(msg.Flags, " ")), nil
+	}
+	return fmt.Sprintf("BODY[%s] %s", spec, quote(content)), nil
+}
+
+func (c *session) store(uid bool, args []arg) ([]string, string) {
+	if c.readOnly {
+		return nil, "NO Mailbox is read-only"
+	}
+	if len(args) < 3 {
+		return nil, "BAD STORE expects a set, an operation and flags"
+	}
+	unchangedSince := int64(-1)
+	if args[1].kind == listArg {
+		if len(args[1].list) != 2 || !strings.EqualFold(args[1].list[0].value, "UNCHANGEDSINCE") || !c.server.has("CONDSTORE") {
+			return nil, "BAD Invalid STORE modifier"
+		}
+		var err error
+		if unchangedSince, err = strconv.ParseInt(args[1].list[1].value, 10, 64); err != nil {
+			return nil, "BAD Invalid UNCHANGEDSINCE"
+		}
+		args = args[1:]
+		if len(args) < 3 {
+			return nil, "BAD STORE expects flags"
+		}
+	}
+	lines := c.updates(false)
+	messages, err := c.messages(uid, args[0].value)
+	if err != nil {
+		return nil, "BAD " + err.Error()
+	}
+	operation := strings.ToUpper(args[1].value)
+	silent := strings.HasSuffix(operation, ".SILENT")
+	operation = strings.TrimSuffix(operation, ".SILENT")
+	flags := []string{}
+	if args[2].kind == listArg {
+		for _, flag := range args[2].list {
+			flags = append(flags, flag.value)
+		}
+	} else {
+		for _, flag := range args[2:] {
+			flags = append(flags, flag.value)
+		}
+	}
+	modified := []uint32{}
+	for _, msg := range messages {
+		if unchangedSince >= 0 && msg.ModSeq > unchangedSince {
+			modified = append(modified, msg.UID)
+			continue
+		}
+		switch operation {
+		case "FLAGS":
+			msg.Flags = slices.Clone(flags)
+		case "+FLAGS":
+			for _, flag := range flags {
+				if !hasFlag(msg.Flags, flag) {
+					msg.Flags = append(msg.Flags, flag)
+				}
+			}
+		case "-FLAGS":
+			msg.Flags = slices.DeleteFunc(msg.Flags, func(flag string) bool {
+				return hasFlag(flags, flag)
+			})
+		default:
+			return nil, "BAD Unknown STORE operation " + operation
+		}
+		c.server.touch(c.selected, msg)
+		c.modseqs[msg.UID] = msg.ModSeq
+		if !silent || unchangedSince >= 0 {
+			lines = append(lines, fmt.Sprintf("* %d FETCH (%s)", c.seq(msg.UID), c.flagItems(msg)))
+		}
+	}
+	c.server.changed()
+	if len(modified) > 0 {
+		return lines, "OK [MODIFIED " + formatSet(modified) + "] Conditional STORE failed"
+	}
+	return lines, "OK STORE completed"
+}
+
+func (c *session) search(uid bool, args []arg) ([]string, string) {
+	lines := c.updates(false)
+	messages, err := c.messages(false, "1:*")
+	if err != nil {
+		return nil, "BAD " + err.Error()
+	}
+	results := []string{}
+	for _, msg := range messages {
+		match, err := c.matches(msg, args)
+		if err != nil {
+			return nil, "BAD " + err.Error()
+		}
+		if !match {
+			continue
+		}
+		if uid {
+			results = append(results, strconv.FormatUint(uint64(msg.UID), 10))
+		} else {
+			results = append(results, strconv.Itoa(c.seq(msg.UID)))
+		}
+	}
+	return append(lines, strings.TrimSpace("* SEARCH "+strings.Join(results, " "))), "OK SEARCH completed"
+}
+
+func (c *session) matches(msg *Message, criteria []arg) (bool, error) {
+	for i := 0; i < len(criteria); i++ {
+		key := strings.ToUpper(criteria[i].value)
+		var match bool
+		switch key {
+		case "ALL":
+			match = true
+		case "SEEN", "FLAGGED", "DELETED", "ANSWERED", "DRAFT":
+			match = hasFlag(msg.Flags, `\`+key)
+		case "UNSEEN", "UNFLAGGED", "UNDELETED", "UNANSWERED", "UNDRAFT":
+			match = !hasFlag(msg.Flags, `\`+strings.TrimPrefix(key, "UN"))
+		case "UID":
+			if i+1 >= len(criteria) {
+				return false, fmt.Errorf("UID expects a set")
+			}
+			i++
+			uids, err := c.messages(true, criteria[i].value)
+			if err != nil {
+				return false, err
+			}
+			match = slices.Contains(uids, msg)
+		case "MODSEQ":
+			if i+1 >= len(criteria) {
+				return false, fmt.Errorf("MODSEQ expects a value")
+			}
+			i++
+			modseq, err := strconv.ParseInt(criteria[i].value, 10, 64)
+			if err != nil {
+				return false, err
+			}
+			match = msg.ModSeq >= modseq
+		default:
+			if criteria[i].kind == listArg {
+				inner, err := c.matches(msg, criteria[i].list)
+				if err != nil {
+					return false, err
+				}
+				match = inner
+				break
+			}
+			seqs, err := c.messages(false, criteria[i].value)
+			if err != nil {
+				return false, fmt.Errorf("unsupported SEARCH key %s", key)
+			}
+			match = slices.Contains(seqs, msg)
+		}
+		if !match {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// copy answers COPY and MOVE, with a COPYUID response code when the server
+// advertises UIDPLUS.
+func (c *session) copy(uid, move bool, args []arg) ([]string, string) {
+	if move && !c.server.has("MOVE") {
+		return nil, "BAD MOVE not supported"
+	}
+	if move && c.readOnly {
+		return nil, "NO Mailbox is read-only"
+	}
+	if len(args) < 2 {
+		return nil, "BAD COPY expects a set and a mailbox"
+	}
+	lines := c.updates(false)
+	messages, err := c.messages(uid, args[0].value)
+	if err != nil {
+		return nil, "BAD " + err.Error()
+	}
+	dest := c.server.mailbox(args[1].value)
+	if dest == nil {
+		return nil, "NO [TRYCREATE] No such mailbox"
+	}
+	source, copied := []uint32{}, []uint32{}
+	for _, msg := range messages {
+		duplicate := *msg
+		duplicate.Flags = slices.Clone(msg.Flags)
+		source = append(source, msg.UID)
+		copied = append(copied, c.server.appendMessage(dest, &duplicate))
+	}
+	code := ""
+	if len(source) > 0 && c.server.has("UIDPLUS") {
+		code = fmt.Sprintf("[COPYUID %d %s %s] ", dest.UIDValidity, formatSet(source), formatSet(copied))
+	}
+	if !move {
+		c.server.changed()
+		return lines, "OK " + code + "COPY completed"
+	}
+	if code != "" {
+		lines = append(lines, "* OK "+code+"Moved")
+	}
+	c.server.expunge(c.selected, source)
+	c.server.changed()
+	return append(lines, c.updates(false)...), "OK MOVE completed"
+}
+
+func (c *session) expungeDeleted(uid bool, args []arg) ([]string, string) {
+	if c.readOnly {
+		return nil, "NO Mailbox is read-only"
+	}
+	deleted := c.flagged(`\Deleted`)
+	if uid {
+		if !c.server.has("UIDPLUS") {
+			return nil, "BAD UID EXPUNGE not supported"
+		}
+		if len(args) == 0 {
+			return nil, "BAD UID EXPUNGE expects a set"
+		}
+		messages, err := c.messages(true, args[0].value)
+		if err != nil {
+			return nil, "BAD " + err.Error()
+		}
+		deleted = slices.DeleteFunc(deleted, func(uid uint32) bool {
+			return !slices.ContainsFunc(messages, func(msg *Message) bool { return msg.UID == uid })
+		})
+	}
+	c.server.expunge(c.selected, deleted)
+	c.server.changed()
+	return c.updates(false), "OK EXPUNGE completed"
+}
+
+func (c *session) flagged(flag string) []uint32 {
+	uids := []uint32{}
+	for _, msg := range c.selected.Messages {
+		if hasFlag(msg.Flags, flag) {
+			uids = append(uids, msg.UID)
+		}
+	}
+	return uids
+}
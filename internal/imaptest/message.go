@@ -0,0 +1,257 @@
+package imaptest
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseEntity splits a message or body part into its header, body and, for
+// multipart and message/rfc822 entities, its children. Malformed input is
+// treated as text/plain rather than rejected.
+func parseEntity(raw string) *entity {
+	e := &entity{header: raw, mime: "text", subtype: "plain", params: map[string]string{}}
+	if index := strings.Index(raw, "\r\n\r\n"); index >= 0 {
+		e.header, e.body = raw[:index+4], raw[index+4:]
+	} else if strings.HasPrefix(raw, "\r\n") {
+		e.header, e.body = "\r\n", raw[2:]
+	}
+	fields, err := textproto.NewReader(bufio.NewReader(strings.NewReader(e.header))).ReadMIMEHeader()
+	if err != nil && len(fields) == 0 {
+		fields = textproto.MIMEHeader{}
+	}
+	e.fields = fields
+	if contentType := fields.Get("Content-Type"); contentType != "" {
+		if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+			e.mime, e.subtype, _ = strings.Cut(mediaType, "/")
+			e.params = params
+		}
+	}
+	switch {
+	case e.mime == "multipart" && e.params["boundary"] != "":
+		for _, part := range splitMultipart(e.body, e.params["boundary"]) {
+			child := parseEntity(part)
+			if e.subtype == "digest" && child.fields.Get("Content-Type") == "" {
+				child.mime, child.subtype = "message", "rfc822"
+			}
+			if child.mime == "message" && child.subtype == "rfc822" {
+				child.message = parseEntity(child.body)
+			}
+			e.parts = append(e.parts, child)
+		}
+	case e.mime == "message" && e.subtype == "rfc822":
+		e.message = parseEntity(e.body)
+	}
+	return e
+}
+
+func splitMultipart(body, boundary string) []string {
+	chunks := strings.Split("\r\n"+body, "\r\n--"+boundary)
+	parts := []string{}
+	for _, chunk := range chunks[1:] {
+		if strings.HasPrefix(chunk, "--") {
+			break
+		}
+		_, part, ok := strings.Cut(chunk, "\r\n")
+		if !ok {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// section returns BODY[spec] of a message, such as "", "TEXT", "1.2",
+// "2.HEADER" or "1.MIME".
+func (e *entity) section(spec string) (string, bool) {
+	if spec == "" {
+		return e.header + e.body, true
+	}
+	current := e
+	parts := strings.Split(spec, ".")
+	isMessage := true
+	for len(parts) > 0 {
+		n, err := strconv.Atoi(parts[0])
+		if err != nil {
+			break
+		}
+		parts = parts[1:]
+		if !isMessage && current.message != nil {
+			current = current.message
+		}
+		if len(current.parts) > 0 {
+			if n < 1 || n > len(current.parts) {
+				return "", false
+			}
+			current = current.parts[n-1]
+		} else if n != 1 {
+			return "", false
+		}
+		isMessage = false
+	}
+	text := strings.Join(parts, ".")
+	if text == "" {
+		return current.body, true
+	}
+	upper := strings.ToUpper(text)
+	if upper == "MIME" {
+		if isMessage {
+			return "", false
+		}
+		return current.header, true
+	}
+	if !isMessage {
+		if current.message == nil {
+			return "", false
+		}
+		current = current.message
+	}
+	switch {
+	case upper == "HEADER":
+		return current.header, true
+	case upper == "TEXT":
+		return current.body, true
+	case strings.HasPrefix(upper, "HEADER.FIELDS"):
+		return current.headerFields(text), true
+	}
+	return "", false
+}
+
+// headerFields answers HEADER.FIELDS (names) and HEADER.FIELDS.NOT (names).
+func (e *entity) headerFields(spec string) string {
+	not := strings.HasPrefix(strings.ToUpper(spec), "HEADER.FIELDS.NOT")
+	names := []string{}
+	if start, end := strings.IndexByte(spec, '('), strings.LastIndexByte(spec, ')'); start >= 0 && end > start {
+		for _, name := range strings.Fields(spec[start+1 : end]) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	var filtered strings.Builder
+	include := false
+	for _, line := range strings.SplitAfter(e.header, "\r\n") {
+		if line == "\r\n" || line == "" {
+			break
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := strings.Cut(line, ":")
+			include = slices.Contains(names, strings.ToLower(strings.TrimSpace(name))) != not
+		}
+		if include {
+			filtered.WriteString(line)
+		}
+	}
+	filtered.WriteString("\r\n")
+	return filtered.String()
+}
+
+func (e *entity) envelope() string {
+	header := func(name string) string {
+		return nstring(e.fields.Get(name))
+	}
+	from := e.addresses("From")
+	sender, replyTo := e.addresses("Sender"), e.addresses("Reply-To")
+	if sender == "NIL" {
+		sender = from
+	}
+	if replyTo == "NIL" {
+		replyTo = from
+	}
+	return fmt.Sprintf("(%s %s %s %s %s %s %s %s %s %s)",
+		header("Date"), header("Subject"), from, sender, replyTo,
+		e.addresses("To"), e.addresses("Cc"), e.addresses("Bcc"),
+		header("In-Reply-To"), header("Message-Id"))
+}
+
+func (e *entity) addresses(name string) string {
+	value := e.fields.Get(name)
+	if value == "" {
+		return "NIL"
+	}
+	list, err := mail.ParseAddressList(value)
+	if err != nil || len(list) == 0 {
+		return "NIL"
+	}
+	addresses := []string{}
+	for _, address := range list {
+		mailbox, host, _ := strings.Cut(address.Address, "@")
+		addresses = append(addresses, fmt.Sprintf("(%s NIL %s %s)", nstring(mime.QEncoding.Encode("utf-8", address.Name)), nstring(mailbox), nstring(host)))
+	}
+	return "(" + strings.Join(addresses, "") + ")"
+}
+
+// structure renders BODYSTRUCTURE, or BODY without the extension data.
+func (e *entity) structure(extended bool) string {
+	if len(e.parts) > 0 {
+		var children strings.Builder
+		for _, part := range e.parts {
+			children.WriteString(part.structure(extended))
+		}
+		result := fmt.Sprintf("(%s %s", children.String(), quote(strings.ToUpper(e.subtype)))
+		if extended {
+			result += fmt.Sprintf(" %s %s NIL NIL", formatParams(e.params), e.disposition())
+		}
+		return result + ")"
+	}
+	encoding := e.fields.Get("Content-Transfer-Encoding")
+	if encoding == "" {
+		encoding = "7BIT"
+	}
+	params := e.params
+	if e.mime == "text" && params["charset"] == "" {
+		params = map[string]string{"charset": "us-ascii"}
+		for key, value := range e.params {
+			params[key] = value
+		}
+	}
+	result := fmt.Sprintf("(%s %s %s %s %s %s %d",
+		quote(strings.ToUpper(e.mime)), quote(strings.ToUpper(e.subtype)), formatParams(params),
+		nstring(e.fields.Get("Content-Id")), nstring(e.fields.Get("Content-Description")),
+		quote(strings.ToUpper(encoding)), len(e.body))
+	switch {
+	case e.message != nil:
+		result += fmt.Sprintf(" %s %s %d", e.message.envelope(), e.message.structure(extended), lineCount(e.body))
+	case e.mime == "text":
+		result += fmt.Sprintf(" %d", lineCount(e.body))
+	}
+	if extended {
+		result += fmt.Sprintf(" NIL %s NIL NIL", e.disposition())
+	}
+	return result + ")"
+}
+
+func (e *entity) disposition() string {
+	disposition, params, err := mime.ParseMediaType(e.fields.Get("Content-Disposition"))
+	if err != nil {
+		return "NIL"
+	}
+	return fmt.Sprintf("(%s %s)", quote(strings.ToUpper(disposition)), formatParams(params))
+}
+
+func formatParams(params map[string]string) string {
+	if len(params) == 0 {
+		return "NIL"
+	}
+	keys := []string{}
+	for key := range params {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	values := []string{}
+	for _, key := range keys {
+		values = append(values, quote(strings.ToUpper(key))+" "+quote(params[key]))
+	}
+	return "(" + strings.Join(values, " ") + ")"
+}
+
+func lineCount(body string) int {
+	lines := strings.Count(body, "\r\n")
+	if body != "" && !strings.HasSuffix(body, "\r\n") {
+		lines++
+	}
+	return lines
+}
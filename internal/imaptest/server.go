@@ -0,0 +1,379 @@
+// Package imaptest runs an in-memory IMAP server for exercising the mail
+// client without a real account. Mailboxes, capabilities and failures are
+// scripted through the Server methods while clients are connected.
+package imaptest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var DefaultCapabilities = []string{
+	"IMAP4rev1", "AUTH=PLAIN", "SASL-IR", "ENABLE", "IDLE", "UIDPLUS", "MOVE",
+	"CONDSTORE", "QRESYNC", "SPECIAL-USE", "LIST-EXTENDED", "LIST-STATUS",
+}
+
+const (
+	DefaultUsername = "user@example.com"
+	DefaultPassword = "password"
+)
+
+var messageCount atomic.Int64
+
+// NewServer starts a server listening on a loopback port with
+// DefaultCapabilities, the default credentials and an empty INBOX.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("imaptest: failed to listen: %v", err))
+	}
+	s := &Server{
+		listener:     listener,
+		capabilities: slices.Clone(DefaultCapabilities),
+		username:     DefaultUsername,
+		password:     DefaultPassword,
+		delimiter:    "/",
+		mailboxes:    map[string]*MailBox{},
+		sessions:     map[*session]bool{},
+	}
+	s.createMailBox("INBOX")
+	s.mailboxes["INBOX"].Subscribed = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.Serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Dial() (net.Conn, error) {
+	return net.Dial("tcp", s.Addr())
+}
+
+// Serve runs a session on conn in the background, for clients connected
+// through something other than Dial such as net.Pipe.
+func (s *Server) Serve(conn net.Conn) {
+	sess := &session{
+		server:  s,
+		enabled: map[string]bool{},
+		modseqs: map[uint32]int64{},
+		notify:  make(chan struct{}, 1),
+	}
+	sess.setConn(conn)
+	s.mu.Lock()
+	s.sessions[sess] = true
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		sess.serve()
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+	}()
+}
+
+// Close stops listening, drops every connection and waits for the sessions
+// to end.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Disconnect drops every open connection, as a server restart would.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+}
+
+// SetCapabilities replaces the advertised capabilities. Extensions that are
+// not advertised are refused, so this also switches CONDSTORE, QRESYNC, IDLE
+// and the other extensions off.
+func (s *Server) SetCapabilities(capabilities ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capabilities = capabilities
+}
+
+func (s *Server) SetCredentials(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+// SetTLSConfig makes the server offer STARTTLS with config.
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tlsConfig = config
+}
+
+// SetBareGreeting makes the greeting leave out the CAPABILITY response
+// code, so clients have to ask for the capabilities.
+func (s *Server) SetBareGreeting(bare bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bareGreeting = bare
+}
+
+// Inject queues a failure for the next command of its kind.
+func (s *Server) Inject(failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failure.Command = strings.ToUpper(failure.Command)
+	s.failures = append(s.failures, failure)
+}
+
+// Commands returns every command received so far without its tag, with
+// credentials left out.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.commands)
+}
+
+// CreateMailBox adds a mailbox with the given attributes, such as `\Sent`.
+func (s *Server) CreateMailBox(name string, attributes ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mailbox(name) != nil {
+		return fmt.Errorf("mailbox %q already exists", name)
+	}
+	mailbox := s.createMailBox(name)
+	mailbox.Attributes = attributes
+	mailbox.Subscribed = true
+	s.changed()
+	return nil
+}
+
+// Append adds a message to a mailbox and returns its UID. Connected clients
+// idling on the mailbox are told about it.
+func (s *Server) Append(name string, msg Message) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailbox := s.mailbox(name)
+	if mailbox == nil {
+		return 0, fmt.Errorf("no mailbox %q", name)
+	}
+	msg.Raw = toCRLF(msg.Raw)
+	if msg.InternalDate.IsZero() {
+		msg.InternalDate = time.Now()
+	}
+	uid := s.appendMessage(mailbox, &msg)
+	s.changed()
+	return uid, nil
+}
+
+// SetFlags replaces the flags of a message.
+func (s *Server) SetFlags(name string, uid uint32, flags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailbox := s.mailbox(name)
+	if mailbox == nil {
+		return fmt.Errorf("no mailbox %q", name)
+	}
+	msg := mailbox.message(uid)
+	if msg == nil {
+		return fmt.Errorf("no message %d in %q", uid, name)
+	}
+	msg.Flags = slices.Clone(flags)
+	s.touch(mailbox, msg)
+	s.changed()
+	return nil
+}
+
+// Expunge removes messages from a mailbox as another client would.
+func (s *Server) Expunge(name string, uids ...uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailbox := s.mailbox(name)
+	if mailbox == nil {
+		return fmt.Errorf("no mailbox %q", name)
+	}
+	s.expunge(mailbox, uids)
+	s.changed()
+	return nil
+}
+
+// SetUIDValidity changes the UIDVALIDITY of a mailbox, which tells clients
+// that every UID they cached is stale.
+func (s *Server) SetUIDValidity(name string, uidValidity uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailbox := s.mailbox(name)
+	if mailbox == nil {
+		return fmt.Errorf("no mailbox %q", name)
+	}
+	mailbox.UIDValidity = uidValidity
+	s.changed()
+	return nil
+}
+
+// MailBox returns a copy of a mailbox and its messages.
+func (s *Server) MailBox(name string) (MailBox, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailbox := s.mailbox(name)
+	if mailbox == nil {
+		return MailBox{}, false
+	}
+	snapshot := *mailbox
+	snapshot.Attributes = slices.Clone(mailbox.Attributes)
+	snapshot.Messages = nil
+	snapshot.expunged = nil
+	for _, msg := range mailbox.Messages {
+		copied := *msg
+		copied.Flags = slices.Clone(msg.Flags)
+		snapshot.Messages = append(snapshot.Messages, &copied)
+	}
+	return snapshot, true
+}
+
+// MailBoxes returns the names of every mailbox, sorted.
+func (s *Server) MailBoxes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.mailboxes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewMessage builds a plain text message.
+func NewMessage(from, to, subject, body string) Message {
+	date := time.Now()
+	raw := fmt.Sprintf("Date: %s\r\nFrom: %s\r\nTo: %s\r\nSubject: %s\r\nMessage-ID: <%d.%d@imaptest>\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		date.Format(time.RFC1123Z), from, to, subject, date.UnixNano(), messageCount.Add(1), toCRLF(body))
+	return Message{Raw: raw, InternalDate: date}
+}
+
+// The methods below are called with s.mu held.
+
+func (s *Server) mailbox(name string) *MailBox {
+	if strings.EqualFold(name, "INBOX") {
+		name = "INBOX"
+	}
+	return s.mailboxes[name]
+}
+
+func (s *Server) createMailBox(name string) *MailBox {
+	s.uidValidity++
+	mailbox := &MailBox{
+		Name:          name,
+		UIDValidity:   s.uidValidity,
+		UIDNext:       1,
+		HighestModSeq: s.nextModSeq(),
+		expunged:      map[uint32]int64{},
+	}
+	s.mailboxes[name] = mailbox
+	return mailbox
+}
+
+func (s *Server) nextModSeq() int64 {
+	s.modseq++
+	return s.modseq
+}
+
+func (s *Server) appendMessage(mailbox *MailBox, msg *Message) uint32 {
+	msg.UID = mailbox.UIDNext
+	mailbox.UIDNext++
+	mailbox.Messages = append(mailbox.Messages, msg)
+	s.touch(mailbox, msg)
+	return msg.UID
+}
+
+// touch gives a message and its mailbox a new modseq after a change.
+func (s *Server) touch(mailbox *MailBox, msg *Message) {
+	msg.ModSeq = s.nextModSeq()
+	mailbox.HighestModSeq = msg.ModSeq
+}
+
+func (s *Server) expunge(mailbox *MailBox, uids []uint32) []uint32 {
+	expunged := []uint32{}
+	mailbox.Messages = slices.DeleteFunc(mailbox.Messages, func(msg *Message) bool {
+		if !slices.Contains(uids, msg.UID) {
+			return false
+		}
+		expunged = append(expunged, msg.UID)
+		return true
+	})
+	if len(expunged) == 0 {
+		return expunged
+	}
+	modseq := s.nextModSeq()
+	mailbox.HighestModSeq = modseq
+	for _, uid := range expunged {
+		mailbox.expunged[uid] = modseq
+	}
+	return expunged
+}
+
+// changed wakes every idling session so it reports the new state.
+func (s *Server) changed() {
+	for sess := range s.sessions {
+		select {
+		case sess.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Server) has(capability string) bool {
+	return slices.ContainsFunc(s.capabilities, func(c string) bool {
+		return strings.EqualFold(c, capability)
+	})
+}
+
+func (s *Server) takeFailure(command string) (Failure, bool) {
+	for i, failure := range s.failures {
+		if failure.Command == command {
+			s.failures = slices.Delete(s.failures, i, i+1)
+			return failure, true
+		}
+	}
+	return Failure{}, false
+}
+
+func (m *MailBox) message(uid uint32) *Message {
+	for _, msg := range m.Messages {
+		if msg.UID == uid {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (m *MailBox) unseen() int {
+	unseen := 0
+	for _, msg := range m.Messages {
+		if !hasFlag(msg.Flags, `\Seen`) {
+			unseen++
+		}
+	}
+	return unseen
+}
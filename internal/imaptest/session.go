@@ -0,0 +1,483 @@
+package imaptest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (c *session) setConn(conn net.Conn) {
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.writer = bufio.NewWriter(conn)
+}
+
+func (c *session) serve() {
+	defer c.conn.Close()
+	c.server.mu.Lock()
+	greeting := "* OK [CAPABILITY " + c.capabilities() + "] imaptest ready"
+	if c.server.bareGreeting {
+		greeting = "* OK imaptest ready"
+	}
+	c.server.mu.Unlock()
+	if err := c.write(greeting); err != nil {
+		return
+	}
+	for {
+		line, err := c.readCommand()
+		if err != nil {
+			return
+		}
+		tag, rest, _ := strings.Cut(line, " ")
+		name, rawArgs := commandName(rest)
+		if tag == "" || name == "" {
+			if err := c.write("* BAD Missing command"); err != nil {
+				return
+			}
+			continue
+		}
+		c.server.mu.Lock()
+		logged := name
+		if rawArgs != "" && name != "LOGIN" && name != "AUTHENTICATE" {
+			logged += " " + rawArgs
+		}
+		c.server.commands = append(c.server.commands, logged)
+		failure, failed := c.server.takeFailure(name)
+		c.server.mu.Unlock()
+		if failed {
+			for _, untagged := range failure.Untagged {
+				if err := c.write(untagged); err != nil {
+					return
+				}
+				if strings.HasPrefix(untagged, "+") {
+					if _, err := c.readLine(); err != nil {
+						return
+					}
+				}
+			}
+			if failure.Close {
+				return
+			}
+			time.Sleep(failure.Delay)
+			if err := c.write(tag + " " + failure.Response); err != nil {
+				return
+			}
+			continue
+		}
+		args, err := parseArgs(rawArgs)
+		if err != nil {
+			if err := c.write(tag + " BAD " + err.Error()); err != nil {
+				return
+			}
+			continue
+		}
+		done, err := c.dispatch(tag, name, args)
+		if err != nil || done {
+			return
+		}
+	}
+}
+
+// dispatch runs one command and reports whether the session is over.
+func (c *session) dispatch(tag, name string, args []arg) (bool, error) {
+	switch name {
+	case "LOGOUT":
+		return true, c.write("* BYE Logging out", tag+" OK LOGOUT completed")
+	case "IDLE":
+		return false, c.idle(tag)
+	case "AUTHENTICATE":
+		return false, c.authenticate(tag, args)
+	case "STARTTLS":
+		return false, c.startTLS(tag)
+	}
+	c.server.mu.Lock()
+	lines, status := c.handle(name, args)
+	c.server.mu.Unlock()
+	return false, c.write(append(lines, tag+" "+status)...)
+}
+
+// handle runs a command that needs nothing but the server state, returning
+// the untagged lines and the tagged status. The caller holds server.mu.
+func (c *session) handle(name string, args []arg) ([]string, string) {
+	switch name {
+	case "CAPABILITY":
+		return []string{"* CAPABILITY " + c.capabilities()}, "OK CAPABILITY completed"
+	case "NOOP", "CHECK":
+		return c.updates(true), "OK " + name + " completed"
+	case "LOGIN":
+		return c.login(args)
+	}
+	if !c.authenticated {
+		return nil, "BAD Not authenticated"
+	}
+	switch name {
+	case "ENABLE":
+		return c.enable(args)
+	case "SELECT", "EXAMINE":
+		return c.selectMailBox(name, args)
+	case "LIST", "LSUB":
+		return c.list(name, args)
+	case "STATUS":
+		return c.status(args)
+	case "CREATE":
+		return c.create(args)
+	case "RENAME":
+		return c.rename(args)
+	case "DELETE":
+		return c.delete(args)
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		return c.subscribe(name == "SUBSCRIBE", args)
+	}
+	if c.selected == nil {
+		return nil, "BAD No mailbox selected"
+	}
+	switch name {
+	case "FETCH", "UID FETCH":
+		return c.fetch(name == "UID FETCH", args)
+	case "STORE", "UID STORE":
+		return c.store(name == "UID STORE", args)
+	case "SEARCH", "UID SEARCH":
+		return c.search(name == "UID SEARCH", args)
+	case "COPY", "UID COPY", "MOVE", "UID MOVE":
+		return c.copy(strings.HasPrefix(name, "UID "), strings.HasSuffix(name, "MOVE"), args)
+	case "EXPUNGE", "UID EXPUNGE":
+		return c.expungeDeleted(name == "UID EXPUNGE", args)
+	case "CLOSE", "UNSELECT":
+		if name == "CLOSE" && !c.readOnly {
+			c.server.expunge(c.selected, c.flagged(`\Deleted`))
+			c.server.changed()
+		}
+		c.selected = nil
+		return nil, "OK " + name + " completed"
+	}
+	return nil, "BAD Unknown command " + name
+}
+
+func (c *session) capabilities() string {
+	capabilities := []string{}
+	for _, capability := range c.server.capabilities {
+		upper := strings.ToUpper(capability)
+		if c.authenticated && (strings.HasPrefix(upper, "AUTH=") || upper == "SASL-IR" || upper == "LOGINDISABLED") {
+			continue
+		}
+		capabilities = append(capabilities, capability)
+	}
+	if c.server.tlsConfig != nil && !c.secure && !c.authenticated {
+		capabilities = append(capabilities, "STARTTLS")
+	}
+	return strings.Join(capabilities, " ")
+}
+
+func (c *session) login(args []arg) ([]string, string) {
+	if c.authenticated {
+		return nil, "BAD Already authenticated"
+	}
+	if c.server.has("LOGINDISABLED") {
+		return nil, "NO LOGIN is disabled"
+	}
+	if len(args) != 2 {
+		return nil, "BAD LOGIN expects a user name and a password"
+	}
+	if args[0].value != c.server.username || args[1].value != c.server.password {
+		return nil, "NO [AUTHENTICATIONFAILED] Invalid credentials"
+	}
+	c.authenticated = true
+	return nil, "OK [CAPABILITY " + c.capabilities() + "] LOGIN completed"
+}
+
+// authenticate supports PLAIN, XOAUTH2 and OAUTHBEARER, where the password
+// stands in for the token. A rejected token gets the JSON error challenge
+// that OAuth servers send, and the client has to answer it before the NO.
+func (c *session) authenticate(tag string, args []arg) error {
+	c.server.mu.Lock()
+	authenticated := c.authenticated
+	c.server.mu.Unlock()
+	if authenticated || len(args) == 0 {
+		return c.write(tag + " BAD Invalid AUTHENTICATE")
+	}
+	mechanism := strings.ToUpper(args[0].value)
+	if mechanism != "PLAIN" && mechanism != "XOAUTH2" && mechanism != "OAUTHBEARER" {
+		return c.write(tag + " NO Unsupported mechanism")
+	}
+	encoded := ""
+	if len(args) > 1 {
+		encoded = args[1].value
+	} else {
+		if err := c.write("+ "); err != nil {
+			return err
+		}
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		encoded = line
+	}
+	if encoded == "*" {
+		return c.write(tag + " BAD AUTHENTICATE cancelled")
+	}
+	response, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return c.write(tag + " BAD Invalid base64")
+	}
+	var username, password string
+	if mechanism == "PLAIN" {
+		fields := strings.Split(string(response), "\x00")
+		if len(fields) == 3 {
+			username, password = fields[1], fields[2]
+		}
+	} else {
+		for _, field := range strings.Split(string(response), "\x01") {
+			if user, ok := strings.CutPrefix(field, "user="); ok {
+				username = user
+			}
+			if header, ok := strings.CutPrefix(field, "n,a="); ok {
+				username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(strings.TrimSuffix(header, ","))
+			}
+			if token, ok := strings.CutPrefix(field, "auth=Bearer "); ok {
+				password = token
+			}
+		}
+	}
+	c.server.mu.Lock()
+	valid := username == c.server.username && password == c.server.password
+	c.authenticated = valid
+	capabilities := c.capabilities()
+	c.server.mu.Unlock()
+	if valid {
+		return c.write(tag + " OK [CAPABILITY " + capabilities + "] AUTHENTICATE completed")
+	}
+	if mechanism != "PLAIN" {
+		challenge := `{"status":"401","schemes":"bearer","scope":"https://mail.example.com/"}`
+		if err := c.write("+ " + base64.StdEncoding.EncodeToString([]byte(challenge))); err != nil {
+			return err
+		}
+		if _, err := c.readLine(); err != nil {
+			return err
+		}
+	}
+	return c.write(tag + " NO [AUTHENTICATIONFAILED] Invalid credentials")
+}
+
+func (c *session) startTLS(tag string) error {
+	c.server.mu.Lock()
+	config := c.server.tlsConfig
+	c.server.mu.Unlock()
+	if config == nil || c.secure {
+		return c.write(tag + " BAD STARTTLS not available")
+	}
+	if err := c.write(tag + " OK Begin TLS negotiation now"); err != nil {
+		return err
+	}
+	conn := tls.Server(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.server.mu.Lock()
+	c.setConn(conn)
+	c.secure = true
+	c.server.mu.Unlock()
+	return nil
+}
+
+func (c *session) enable(args []arg) ([]string, string) {
+	enabled := []string{}
+	for _, extension := range args {
+		name := strings.ToUpper(extension.value)
+		if (name == "QRESYNC" || name == "CONDSTORE") && c.server.has(name) {
+			c.enabled[name] = true
+			enabled = append(enabled, name)
+		}
+	}
+	if c.enabled["QRESYNC"] {
+		c.enabled["CONDSTORE"] = true
+	}
+	return []string{strings.TrimSpace("* ENABLED " + strings.Join(enabled, " "))}, "OK ENABLE completed"
+}
+
+// idle answers IDLE, reporting changes to the selected mailbox as they
+// happen until the client sends DONE.
+func (c *session) idle(tag string) error {
+	c.server.mu.Lock()
+	supported := c.server.has("IDLE") && c.authenticated
+	c.server.mu.Unlock()
+	if !supported {
+		return c.write(tag + " BAD IDLE not supported")
+	}
+	if err := c.write("+ idling"); err != nil {
+		return err
+	}
+	lines := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		line, err := c.readLine()
+		if err != nil {
+			errs <- err
+			return
+		}
+		lines <- line
+	}()
+	for {
+		c.server.mu.Lock()
+		updates := c.updates(true)
+		c.server.mu.Unlock()
+		if err := c.write(updates...); err != nil {
+			return err
+		}
+		select {
+		case <-c.notify:
+		case line := <-lines:
+			if !strings.EqualFold(strings.TrimSpace(line), "DONE") {
+				return c.write(tag + " BAD Expected DONE")
+			}
+			return c.write(tag + " OK IDLE terminated")
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// updates reports what changed in the selected mailbox since this session
+// last looked: expunged messages, new messages and, when withFlags is set,
+// changed flags. The caller holds server.mu.
+func (c *session) updates(withFlags bool) []string {
+	if c.selected == nil {
+		return nil
+	}
+	lines := []string{}
+	vanished := []uint32{}
+	for i := len(c.uids) - 1; i >= 0; i-- {
+		uid := c.uids[i]
+		if c.selected.message(uid) != nil {
+			continue
+		}
+		if c.enabled["QRESYNC"] {
+			vanished = append(vanished, uid)
+		} else {
+			lines = append(lines, fmt.Sprintf("* %d EXPUNGE", i+1))
+		}
+		c.uids = append(c.uids[:i], c.uids[i+1:]...)
+		delete(c.modseqs, uid)
+	}
+	if len(vanished) > 0 {
+		lines = append(lines, "* VANISHED "+formatSet(vanished))
+	}
+	if withFlags {
+		for i, uid := range c.uids {
+			msg := c.selected.message(uid)
+			if msg.ModSeq <= c.modseqs[uid] {
+				continue
+			}
+			c.modseqs[uid] = msg.ModSeq
+			lines = append(lines, fmt.Sprintf("* %d FETCH (%s)", i+1, c.flagItems(msg)))
+		}
+	}
+	added := false
+	for _, msg := range c.selected.Messages {
+		if len(c.uids) == 0 || msg.UID > c.uids[len(c.uids)-1] {
+			c.uids = append(c.uids, msg.UID)
+			c.modseqs[msg.UID] = msg.ModSeq
+			added = true
+		}
+	}
+	if added {
+		lines = append(lines, fmt.Sprintf("* %d EXISTS", len(c.uids)))
+	}
+	return lines
+}
+
+func (c *session) flagItems(msg *Message) string {
+	items := fmt.Sprintf("UID %d FLAGS (%s)", msg.UID, strings.Join(msg.Flags, " "))
+	if c.server.has("CONDSTORE") {
+		items += fmt.Sprintf(" MODSEQ (%d)", msg.ModSeq)
+	}
+	return items
+}
+
+// seq returns the sequence number of a message as this session knows it,
+// or 0 when the session has not been told about it yet.
+func (c *session) seq(uid uint32) int {
+	for i, known := range c.uids {
+		if known == uid {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (c *session) write(lines ...string) error {
+	for _, line := range lines {
+		if _, err := c.writer.WriteString(line + "\r\n"); err != nil {
+			return err
+		}
+	}
+	return c.writer.Flush()
+}
+
+func (c *session) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readCommand reads a command line, inlining any literals it carries.
+func (c *session) readCommand() (string, error) {
+	var command strings.Builder
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", err
+		}
+		size, synchronizing, ok := literalSize(line)
+		if !ok {
+			command.WriteString(line)
+			return command.String(), nil
+		}
+		if synchronizing {
+			if err := c.write("+ Ready for literal data"); err != nil {
+				return "", err
+			}
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, literal); err != nil {
+			return "", err
+		}
+		command.WriteString(line[:strings.LastIndexByte(line, '{')])
+		command.WriteString(quote(string(literal)))
+	}
+}
+
+func literalSize(line string) (int, bool, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false, false
+	}
+	start := strings.LastIndexByte(line, '{')
+	if start < 0 {
+		return 0, false, false
+	}
+	spec := line[start+1 : len(line)-1]
+	synchronizing := !strings.HasSuffix(spec, "+")
+	size, err := strconv.Atoi(strings.TrimSuffix(spec, "+"))
+	if err != nil {
+		return 0, false, false
+	}
+	return size, synchronizing, true
+}
+
+func commandName(rest string) (string, string) {
+	name, args, _ := strings.Cut(rest, " ")
+	name = strings.ToUpper(name)
+	if name == "UID" {
+		var sub string
+		sub, args, _ = strings.Cut(args, " ")
+		name += " " + strings.ToUpper(sub)
+	}
+	return name, args
+}
@@ -0,0 +1,111 @@
+package imaptest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// Message is one message in a fake mailbox. UID and ModSeq are assigned by
+// the server when the message is appended.
+type Message struct {
+	UID          uint32
+	Flags        []string
+	InternalDate time.Time
+	ModSeq       int64
+	Raw          string
+}
+
+type MailBox struct {
+	Name          string
+	Attributes    []string
+	Subscribed    bool
+	UIDValidity   uint32
+	UIDNext       uint32
+	HighestModSeq int64
+	Messages      []*Message
+	// expunged keeps the modseq each UID was expunged at, for QRESYNC.
+	expunged map[uint32]int64
+}
+
+// Failure replaces the server's answer to the next command named Command,
+// such as "SELECT" or "UID FETCH". Untagged lines are sent first, then the
+// tagged Response, e.g. `NO [ALERT] Mailbox is over quota`. A continuation
+// request among them, such as a SASL challenge starting with "+", waits for
+// the client's answer. With Close the connection is dropped after the
+// untagged lines instead. Delay stalls the server after the untagged lines,
+// as a hung server would.
+type Failure struct {
+	Command  string
+	Untagged []string
+	Response string
+	Close    bool
+	Delay    time.Duration
+}
+
+// Server is a scriptable IMAP server that keeps its mailboxes in memory.
+type Server struct {
+	mu           sync.Mutex
+	wg           sync.WaitGroup
+	listener     net.Listener
+	capabilities []string
+	username     string
+	password     string
+	tlsConfig    *tls.Config
+	bareGreeting bool
+	delimiter    string
+	mailboxes    map[string]*MailBox
+	modseq       int64
+	uidValidity  uint32
+	failures     []Failure
+	commands     []string
+	sessions     map[*session]bool
+}
+
+type session struct {
+	server        *Server
+	conn          net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	secure        bool
+	authenticated bool
+	enabled       map[string]bool
+	selected      *MailBox
+	readOnly      bool
+	// uids and modseqs are the mailbox as this session last reported it, so
+	// sequence numbers and unsolicited updates stay consistent.
+	uids    []uint32
+	modseqs map[uint32]int64
+	notify  chan struct{}
+}
+
+type argKind int
+
+const (
+	atomArg argKind = iota
+	stringArg
+	listArg
+)
+
+// arg is one parsed command argument.
+type arg struct {
+	kind  argKind
+	value string
+	list  []arg
+}
+
+// entity is a parsed MIME entity. message is set for message/rfc822 parts
+// and holds the embedded message.
+type entity struct {
+	header  string
+	body    string
+	fields  textproto.MIMEHeader
+	mime    string
+	subtype string
+	params  map[string]string
+	parts   []*entity
+	message *entity
+}
@@ -0,0 +1,248 @@
+package imaptest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseArgs splits command arguments into atoms, strings and parenthesized
+// lists. Brackets stay part of their atom, so BODY.PEEK[HEADER.FIELDS (To)]
+// is a single argument.
+func parseArgs(s string) ([]arg, error) {
+	args, rest, err := parseList(s, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return args, nil
+}
+
+func parseList(s string, nested bool) ([]arg, string, error) {
+	args := []arg{}
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			if nested {
+				return nil, "", errors.New("unterminated list")
+			}
+			return args, "", nil
+		}
+		switch s[0] {
+		case ')':
+			if !nested {
+				return nil, s, nil
+			}
+			return args, s[1:], nil
+		case '(':
+			list, rest, err := parseList(s[1:], true)
+			if err != nil {
+				return nil, "", err
+			}
+			args = append(args, arg{kind: listArg, list: list})
+			s = rest
+		case '"':
+			value, rest, err := parseQuoted(s)
+			if err != nil {
+				return nil, "", err
+			}
+			args = append(args, arg{kind: stringArg, value: value})
+			s = rest
+		case '{':
+			value, rest, err := parseLiteral(s)
+			if err != nil {
+				return nil, "", err
+			}
+			args = append(args, arg{kind: stringArg, value: value})
+			s = rest
+		default:
+			end, depth := 0, 0
+			for end < len(s) {
+				ch := s[end]
+				if depth == 0 && (ch == ' ' || ch == '(' || ch == ')') {
+					break
+				}
+				if ch == '[' {
+					depth++
+				} else if ch == ']' && depth > 0 {
+					depth--
+				}
+				end++
+			}
+			args = append(args, arg{kind: atomArg, value: s[:end]})
+			s = s[end:]
+		}
+	}
+}
+
+func parseQuoted(s string) (string, string, error) {
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return value.String(), s[i+1:], nil
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", errors.New("unterminated quoted string")
+}
+
+func parseLiteral(s string) (string, string, error) {
+	end := strings.Index(s, "}\r\n")
+	if end < 0 {
+		return "", "", errors.New("malformed literal")
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(s[1:end], "+"))
+	start := end + 3
+	if err != nil || start+size > len(s) {
+		return "", "", errors.New("malformed literal")
+	}
+	return s[start : start+size], s[start+size:], nil
+}
+
+// quote renders a string as a quoted string, or as a literal when it holds
+// line breaks or non-ASCII bytes.
+func quote(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\r' || s[i] == '\n' || s[i] > 0x7e {
+			return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+		}
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(s) + `"`
+}
+
+func nstring(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	return quote(s)
+}
+
+// parseSet expands a sequence set, with * standing for largest.
+func parseSet(set string, largest uint32) ([]uint32, error) {
+	nums := []uint32{}
+	parseNum := func(s string) (uint32, error) {
+		if s == "*" {
+			return largest, nil
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid sequence set %q", set)
+		}
+		return uint32(n), nil
+	}
+	for _, part := range strings.Split(set, ",") {
+		start, end, isRange := strings.Cut(part, ":")
+		first, err := parseNum(start)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parseNum(end); err != nil {
+				return nil, err
+			}
+		}
+		if first > last {
+			first, last = last, first
+		}
+		for n := first; n <= last && n != 0; n++ {
+			nums = append(nums, n)
+			if n == last {
+				break
+			}
+		}
+	}
+	return nums, nil
+}
+
+// formatSet renders numbers as a set, joining runs into ranges while keeping
+// their order so COPYUID source and destination sets line up.
+func formatSet(nums []uint32) string {
+	parts := []string{}
+	for i := 0; i < len(nums); {
+		j := i
+		for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.FormatUint(uint64(nums[i]), 10))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%d", nums[i], nums[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// matchPattern matches a LIST pattern, where * matches anything and %
+// anything but the hierarchy delimiter.
+func matchPattern(pattern, name, delimiter string) bool {
+	if strings.EqualFold(pattern, "INBOX") && strings.EqualFold(name, "INBOX") {
+		return true
+	}
+	if pattern == "" {
+		return name == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(name); i++ {
+			if matchPattern(pattern[1:], name[i:], delimiter) {
+				return true
+			}
+		}
+		return false
+	case '%':
+		for i := 0; i <= len(name); i++ {
+			if matchPattern(pattern[1:], name[i:], delimiter) {
+				return true
+			}
+			if delimiter != "" && strings.HasPrefix(name[i:], delimiter) {
+				return false
+			}
+		}
+		return false
+	}
+	return name != "" && pattern[0] == name[0] && matchPattern(pattern[1:], name[1:], delimiter)
+}
+
+// fetchItemNames expands the FETCH macros and unwraps an item list.
+func fetchItemNames(items arg) []string {
+	if items.kind != listArg {
+		switch strings.ToUpper(items.value) {
+		case "ALL":
+			return []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE"}
+		case "FAST":
+			return []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE"}
+		case "FULL":
+			return []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODY"}
+		}
+		return []string{items.value}
+	}
+	names := []string{}
+	for _, item := range items.list {
+		names = append(names, item.value)
+	}
+	return names
+}
+
+func hasFlag(flags []string, flag string) bool {
+	return slices.ContainsFunc(flags, func(f string) bool {
+		return strings.EqualFold(f, flag)
+	})
+}
+
+func toCRLF(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
@@ -0,0 +1,226 @@
+package mails
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// TokenSource supplies OAuth2 access tokens for XOAUTH2 and OAUTHBEARER.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// FileTokenSource reads the access token from a file on every login, so an
+// external refresher can rotate it in place.
+type FileTokenSource struct {
+	Path string
+}
+
+func (f FileTokenSource) Token(ctx context.Context) (string, error) {
+	content, err := os.ReadFile(f.Path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", f.Path)
+	}
+	return token, nil
+}
+
+// CommandTokenSource runs a helper command through the shell and uses its
+// standard output as the access token.
+type CommandTokenSource struct {
+	Command string
+}
+
+func (c CommandTokenSource) Token(ctx context.Context) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("token command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		return "", errors.New("token command printed no token")
+	}
+	return token, nil
+}
+
+func tokenSourceFromEnv() TokenSource {
+	if command := os.Getenv("IMAP_TOKEN_COMMAND"); command != "" {
+		return CommandTokenSource{Command: command}
+	}
+	if path := os.Getenv("IMAP_TOKEN_FILE"); path != "" {
+		return FileTokenSource{Path: path}
+	}
+	return nil
+}
+
+// SASLMechanism is a client-side SASL exchange. Start returns the initial
+// response and Next answers each server challenge.
+type SASLMechanism interface {
+	Name() string
+	Start() ([]byte, error)
+	Next(challenge []byte) ([]byte, error)
+}
+
+type plainMechanism struct {
+	username string
+	password string
+}
+
+func PlainAuth(username, password string) SASLMechanism {
+	return &plainMechanism{username: username, password: password}
+}
+
+func (p *plainMechanism) Name() string {
+	return "PLAIN"
+}
+
+func (p *plainMechanism) Start() ([]byte, error) {
+	return []byte("\x00" + p.username + "\x00" + p.password), nil
+}
+
+func (p *plainMechanism) Next(challenge []byte) ([]byte, error) {
+	return nil, errors.New("unexpected PLAIN challenge")
+}
+
+type xoauth2Mechanism struct {
+	username string
+	token    string
+}
+
+func XOAuth2(username, token string) SASLMechanism {
+	return &xoauth2Mechanism{username: username, token: token}
+}
+
+func (x *xoauth2Mechanism) Name() string {
+	return "XOAUTH2"
+}
+
+func (x *xoauth2Mechanism) Start() ([]byte, error) {
+	return []byte("user=" + x.username + "\x01auth=Bearer " + x.token + "\x01\x01"), nil
+}
+
+// Next receives the JSON error document sent on failure; answering with an
+// empty response lets the server finish with a tagged NO.
+func (x *xoauth2Mechanism) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
+
+type oauthBearerMechanism struct {
+	username string
+	token    string
+	host     string
+	port     string
+}
+
+func OAuthBearer(username, token, host, port string) SASLMechanism {
+	return &oauthBearerMechanism{username: username, token: token, host: host, port: port}
+}
+
+func (o *oauthBearerMechanism) Name() string {
+	return "OAUTHBEARER"
+}
+
+func (o *oauthBearerMechanism) Start() ([]byte, error) {
+	var message strings.Builder
+	message.WriteString("n,a=" + saslName(o.username) + ",\x01")
+	if o.host != "" {
+		message.WriteString("host=" + o.host + "\x01")
+	}
+	if o.port != "" {
+		message.WriteString("port=" + o.port + "\x01")
+	}
+	message.WriteString("auth=Bearer " + o.token + "\x01\x01")
+	return []byte(message.String()), nil
+}
+
+// Next answers the error challenge with the dummy response required by
+// RFC 7628 section 3.2.3.
+func (o *oauthBearerMechanism) Next(challenge []byte) ([]byte, error) {
+	return []byte{0x01}, nil
+}
+
+func saslName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+func (m *MailClient) Authenticate(ctx context.Context, mechanism SASLMechanism) error {
+	if !slices.Contains(m.Capabilities.AuthMechanisms(), mechanism.Name()) {
+		return fmt.Errorf("server does not support AUTHENTICATE %s", mechanism.Name())
+	}
+	initial, err := mechanism.Start()
+	if err != nil {
+		return err
+	}
+	args := mechanism.Name()
+	initialSent := false
+	if m.Capabilities.Has(CapSASLIR) {
+		args += " " + encodeSASL(initial)
+		initialSent = true
+	}
+	m.Capabilities = nil
+	code, err := m.SendMessage(ctx, "AUTHENTICATE", args)
+	if err != nil {
+		return err
+	}
+	var mechanismErr error
+	for {
+		resp, err := m.readResponse(ctx)
+		if err != nil {
+			return err
+		}
+		m.updateCapabilities(resp)
+		switch resp.Tag {
+		case "+":
+			var response []byte
+			if !initialSent {
+				response, initialSent = initial, true
+			} else {
+				challenge, err := base64.StdEncoding.DecodeString(resp.Text)
+				if err == nil {
+					response, err = mechanism.Next(challenge)
+				}
+				if err != nil {
+					mechanismErr = err
+					if err := m.writeLine(ctx, "*", false); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := m.writeLine(ctx, base64.StdEncoding.EncodeToString(response), true); err != nil {
+				return err
+			}
+		case code:
+			if mechanismErr != nil {
+				return mechanismErr
+			}
+			if resp.Name != "OK" {
+				return m.statusError(resp)
+			}
+			if m.Capabilities == nil {
+				return m.Capability(ctx)
+			}
+			return nil
+		}
+	}
+}
+
+func encodeSASL(response []byte) string {
+	if len(response) == 0 {
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(response)
+}
@@ -0,0 +1,261 @@
+package mails
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/milkymilky0116/jellyfish/internal/imaptest"
+)
+
+const testToken = "s3cret-token"
+
+// greet connects to srv through a Recorder and reads the greeting, leaving
+// the client ready to log in. The transcript and the trace end up in the
+// returned buffers.
+func greet(t *testing.T, srv *imaptest.Server) (*MailClient, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript, trace := &bytes.Buffer{}, &bytes.Buffer{}
+	client := InitMails(NewRecorder(conn, transcript), nil)
+	client.Tracer = NewTracer(trace, 0)
+	client.AllowInsecureAuth = true
+	t.Cleanup(func() { client.Close() })
+	if err := client.ReadGreeting(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return client, transcript, trace
+}
+
+func TestAuthenticate(t *testing.T) {
+	mechanisms := []struct {
+		name      string
+		mechanism func(token string) SASLMechanism
+	}{
+		{"PLAIN", func(token string) SASLMechanism {
+			return PlainAuth(imaptest.DefaultUsername, token)
+		}},
+		{"XOAUTH2", func(token string) SASLMechanism {
+			return XOAuth2(imaptest.DefaultUsername, token)
+		}},
+		{"OAUTHBEARER", func(token string) SASLMechanism {
+			return OAuthBearer(imaptest.DefaultUsername, token, "127.0.0.1", "993")
+		}},
+	}
+	for _, mech := range mechanisms {
+		for _, saslIR := range []bool{true, false} {
+			for _, valid := range []bool{true, false} {
+				name := mech.name
+				if !saslIR {
+					name += " without SASL-IR"
+				}
+				if !valid {
+					name += " rejected"
+				}
+				t.Run(name, func(t *testing.T) {
+					srv := newServer(t)
+					srv.SetCredentials(imaptest.DefaultUsername, testToken)
+					capabilities := []string{"IMAP4rev1", "IDLE", "AUTH=" + mech.name}
+					if saslIR {
+						capabilities = append(capabilities, "SASL-IR")
+					}
+					srv.SetCapabilities(capabilities...)
+					client, transcript, trace := greet(t, srv)
+
+					token := testToken
+					if !valid {
+						token = "wrong-token"
+					}
+					mechanism := mech.mechanism(token)
+					err := client.Authenticate(context.Background(), mechanism)
+					if valid {
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !client.Capabilities.Has(CapIdle) {
+							t.Errorf("capabilities not refreshed: %v", client.Capabilities)
+						}
+					} else {
+						var statusErr *StatusError
+						if !errors.As(err, &statusErr) || statusErr.Command != "AUTHENTICATE" || !HasCode(err, CodeAuthenticationFailed) {
+							t.Fatalf("err = %v, want AUTHENTICATE AUTHENTICATIONFAILED", err)
+						}
+						if client.Err() != nil {
+							t.Errorf("Err() = %v", client.Err())
+						}
+					}
+
+					command := `C: "a001 AUTHENTICATE ` + mech.name
+					if saslIR {
+						command += ` <redacted>\r\n"`
+					} else {
+						command += `\r\n"`
+					}
+					if !strings.Contains(transcript.String(), command) {
+						t.Errorf("transcript lacks %s:\n%s", command, transcript)
+					}
+					initial, _ := mechanism.Start()
+					for name, output := range map[string]string{"transcript": transcript.String(), "trace": trace.String()} {
+						if strings.Contains(output, token) || strings.Contains(output, base64.StdEncoding.EncodeToString(initial)) {
+							t.Errorf("%s leaks the credentials:\n%s", name, output)
+						}
+					}
+					if !saslIR && !strings.Contains(transcript.String(), `C: "<redacted>\r\n"`) {
+						t.Errorf("continuation not redacted:\n%s", transcript)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestAuthenticateUnsupported(t *testing.T) {
+	srv := newServer(t)
+	srv.SetCapabilities("IMAP4rev1", "AUTH=PLAIN")
+	client, _, _ := greet(t, srv)
+	n := len(srv.Commands())
+	if err := client.Authenticate(context.Background(), XOAuth2(imaptest.DefaultUsername, testToken)); err == nil {
+		t.Fatal("AUTHENTICATE XOAUTH2 without AUTH=XOAUTH2 succeeded")
+	}
+	if got := sentSince(srv, n); len(got) != 0 {
+		t.Errorf("sent %v", got)
+	}
+}
+
+func TestAuthenticateBadChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+	}{
+		{"invalid base64", "+ not base64!"},
+		{"unexpected challenge", "+ " + base64.StdEncoding.EncodeToString([]byte("more please"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			client, _, trace := greet(t, srv)
+			srv.Inject(imaptest.Failure{
+				Command:  "AUTHENTICATE",
+				Untagged: []string{tt.challenge},
+				Response: "BAD AUTHENTICATE cancelled",
+			})
+			ctx := context.Background()
+			err := client.Authenticate(ctx, PlainAuth(imaptest.DefaultUsername, imaptest.DefaultPassword))
+			var statusErr *StatusError
+			if err == nil || errors.As(err, &statusErr) {
+				t.Fatalf("err = %v, want the mechanism's error", err)
+			}
+			if !strings.Contains(trace.String(), "line=*\n") {
+				t.Errorf("exchange was not cancelled with *:\n%s", trace)
+			}
+			// The cancelled exchange leaves the connection in step.
+			if client.Err() != nil {
+				t.Fatalf("Err() = %v", client.Err())
+			}
+			if err := client.Capability(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Authenticate(ctx, PlainAuth(imaptest.DefaultUsername, imaptest.DefaultPassword)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLoginMechanism(t *testing.T) {
+	tests := []struct {
+		name         string
+		auth         string
+		capabilities []string
+		command      string
+	}{
+		{"login", "", imaptest.DefaultCapabilities, "LOGIN"},
+		{"login disabled", "", []string{"IMAP4rev1", "AUTH=PLAIN", "LOGINDISABLED"}, "AUTHENTICATE"},
+		{"plain", "PLAIN", imaptest.DefaultCapabilities, "AUTHENTICATE"},
+		{"xoauth2", "XOAUTH2", []string{"IMAP4rev1", "AUTH=XOAUTH2", "SASL-IR"}, "AUTHENTICATE"},
+		{"oauthbearer", "OAUTHBEARER", []string{"IMAP4rev1", "AUTH=OAUTHBEARER"}, "AUTHENTICATE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.SetCapabilities(tt.capabilities...)
+			tokenFile := filepath.Join(t.TempDir(), "token")
+			if err := os.WriteFile(tokenFile, []byte(imaptest.DefaultPassword+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("IMAP_AUTH", tt.auth)
+			t.Setenv("IMAP_TOKEN_FILE", tokenFile)
+			connect(t, srv, dbPath(t))
+			if commands := srv.Commands(); !slices.Contains(commands, tt.command) {
+				t.Errorf("commands = %v, want %s", commands, tt.command)
+			}
+		})
+	}
+}
+
+func TestRecorderRedaction(t *testing.T) {
+	srv := newServer(t)
+	srv.SetCapabilities("IMAP4rev1", "AUTH=XOAUTH2", "IDLE")
+	srv.SetCredentials(imaptest.DefaultUsername, testToken)
+	client, transcript, trace := greet(t, srv)
+	ctx := context.Background()
+
+	// The answer to the error challenge is part of the exchange too.
+	err := client.Authenticate(ctx, XOAuth2(imaptest.DefaultUsername, "wrong-token"))
+	if !HasCode(err, CodeAuthenticationFailed) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := client.Capability(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Authenticate(ctx, XOAuth2(imaptest.DefaultUsername, testToken)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	lines := strings.Split(strings.TrimSpace(transcript.String()), "\n")
+	redactedLines := 0
+	for _, line := range lines {
+		if line == `C: "<redacted>\r\n"` {
+			redactedLines++
+		}
+	}
+	// The initial responses of both attempts and the answer to the challenge.
+	if redactedLines != 3 {
+		t.Errorf("%d redacted lines, want 3:\n%s", redactedLines, transcript)
+	}
+	if !slices.Contains(lines, `C: "a004 SELECT \"INBOX\"\r\n"`) {
+		t.Errorf("commands after AUTHENTICATE are redacted too:\n%s", transcript)
+	}
+	if strings.Contains(transcript.String(), "wrong-token") || strings.Contains(transcript.String(), testToken) {
+		t.Errorf("transcript leaks a token:\n%s", transcript)
+	}
+	if got := strings.Count(trace.String(), "line=<redacted>"); got != 3 {
+		t.Errorf("%d redacted trace lines, want 3:\n%s", got, trace)
+	}
+}
+
+func TestRedactLogin(t *testing.T) {
+	var transcript bytes.Buffer
+	recorder := NewRecorder(&bytes.Buffer{}, &transcript)
+	recorder.Write([]byte("a001 LOGIN \"user\" \"hunter2\"\r\na002 NOOP\r\n"))
+	want := "C: \"a001 LOGIN <redacted>\\r\\n\"\nC: \"a002 NOOP\\r\\n\"\n"
+	if transcript.String() != want {
+		t.Errorf("transcript = %q, want %q", transcript.String(), want)
+	}
+	if got := redactCommand("a001", "LOGIN", `"user" "hunter2"`); got != `a001 LOGIN "user" <redacted>` {
+		t.Errorf("redactCommand = %q", got)
+	}
+}
@@ -0,0 +1,247 @@
+package mails
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// FetchBody returns the readable text of an email, serving it from the
+// email_body cache when it has been opened before. When the BODYSTRUCTURE
+// is known only the display part is downloaded.
+func (m *MailClient) FetchBody(ctx context.Context, mailbox string, email repository.Email) (string, error) {
+	cached, err := m.CacheRepository.GetEmailBody(ctx, email.ID)
+	if err == nil {
+		return cached.Body, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	structure, err := EmailStructure(email)
+	if err != nil {
+		return "", err
+	}
+	var part *BodyPart
+	if structure != nil {
+		part = structure.TextPart()
+		if part == nil {
+			return "", nil
+		}
+	}
+	var raw string
+	err = m.Do(ctx, func() error {
+		if m.CurrentMailBox != mailbox {
+			if err := m.SelectMailBox(ctx, mailbox); err != nil {
+				return err
+			}
+		}
+		m.mu.RLock()
+		category, ok := m.Emails[mailbox]
+		stale := ok && int64(category.UIDValidity) != email.Uidvalidity
+		m.mu.RUnlock()
+		if stale {
+			return errors.New("message is no longer available on the server")
+		}
+		section := ""
+		if part != nil {
+			section = part.Section
+		}
+		raw, err = m.FetchSection(ctx, uint32(email.Uid), section)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	var body string
+	if part != nil {
+		body, err = decodeSection(part, raw)
+	} else {
+		body, err = decodeTextBody(raw)
+	}
+	if err != nil {
+		return "", err
+	}
+	saveBodyParam := repository.SaveEmailBodyParams{
+		EmailID: email.ID,
+		Body:    body,
+	}
+	err = m.CacheRepository.SaveEmailBody(ctx, saveBodyParam)
+	if err != nil {
+		return "", err
+	}
+	return body, nil
+}
+
+// FetchSection downloads BODY[section] of a message; an empty section is the
+// whole raw message.
+func (m *MailClient) FetchSection(ctx context.Context, uid uint32, section string) (string, error) {
+	code, err := m.SendMessage(ctx, "UID FETCH", fmt.Sprintf("%d (UID BODY.PEEK[%s])", uid, section))
+	if err != nil {
+		return "", err
+	}
+	content, _, err := m.ParseIMAPContent(ctx, code)
+	if err != nil {
+		return "", err
+	}
+	for _, resp := range content {
+		if resp.Name != "FETCH" {
+			continue
+		}
+		if body, ok := fetchItems(resp.Fields)["BODY["+section+"]"]; ok {
+			return body.String(), nil
+		}
+	}
+	return "", fmt.Errorf("message %d not found", uid)
+}
+
+func decodeSection(part *BodyPart, raw string) (string, error) {
+	decoded, err := decodePartBody(part.Encoding, part.Params["charset"], strings.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	if part.Subtype == "html" {
+		decoded = htmlToText(decoded)
+	}
+	return strings.ReplaceAll(decoded, "\r\n", "\n"), nil
+}
+
+func decodeTextBody(raw string) (string, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	text, _, err := textFromPart(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(text, "\r\n", "\n"), nil
+}
+
+// textFromPart walks a MIME entity and returns the first text/plain body it
+// finds, falling back to text/html converted to plain text.
+func textFromPart(header textproto.MIMEHeader, body io.Reader) (string, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition")); disposition == "attachment" {
+		return "", false, nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		htmlFallback := ""
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", false, err
+			}
+			text, isHTML, err := textFromPart(part.Header, part)
+			if err != nil {
+				return "", false, err
+			}
+			if text != "" && !isHTML {
+				return text, false, nil
+			}
+			if isHTML && htmlFallback == "" {
+				htmlFallback = text
+			}
+		}
+		return htmlFallback, htmlFallback != "", nil
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", false, nil
+	}
+	decoded, err := decodePartBody(header.Get("Content-Transfer-Encoding"), params["charset"], body)
+	if err != nil {
+		return "", false, err
+	}
+	if mediaType == "text/html" {
+		return htmlToText(decoded), true, nil
+	}
+	return decoded, false, nil
+}
+
+func decodePartBody(encoding, charsetLabel string, body io.Reader) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	if charsetLabel != "" {
+		reader, err := charset.NewReaderLabel(charsetLabel, body)
+		if err == nil {
+			body = reader
+		}
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func collapseSpaces(s string) string {
+	collapsed := strings.Join(strings.Fields(s), " ")
+	if collapsed == "" {
+		if s != "" {
+			return " "
+		}
+		return ""
+	}
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(s, " \t\r\n") != s {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+func htmlToText(document string) string {
+	var text strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	skip := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(text.String())
+		case html.TextToken:
+			if skip == 0 {
+				text.WriteString(collapseSpaces(string(tokenizer.Text())))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style", "head":
+				skip++
+			case "br", "p", "div", "tr", "li", "h1", "h2", "h3", "h4", "h5", "h6":
+				text.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style", "head":
+				skip = max(skip-1, 0)
+			case "p", "div", "table":
+				text.WriteString("\n")
+			}
+		}
+	}
+}
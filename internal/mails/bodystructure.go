@@ -0,0 +1,214 @@
+package mails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+// ParseBodyStructure converts a BODYSTRUCTURE fetch item into a part tree
+// numbered the way BODY[section] expects.
+func ParseBodyStructure(field Field) (*BodyPart, error) {
+	if field.Type != ListField || len(field.List) == 0 {
+		return nil, errors.New("BODYSTRUCTURE is not a list")
+	}
+	if field.List[0].Type == ListField {
+		return parseBodyPart(field, "")
+	}
+	return parseBodyPart(field, "1")
+}
+
+func parseBodyPart(field Field, section string) (*BodyPart, error) {
+	if field.Type != ListField || len(field.List) == 0 {
+		return nil, fmt.Errorf("malformed body part %s", section)
+	}
+	if field.List[0].Type == ListField {
+		return parseMultipart(field.List, section)
+	}
+	return parseSinglePart(field.List, section)
+}
+
+func parseMultipart(list []Field, section string) (*BodyPart, error) {
+	part := &BodyPart{Type: "multipart", Section: section}
+	i := 0
+	for ; i < len(list) && list[i].Type == ListField; i++ {
+		child, err := parseBodyPart(list[i], childSection(section, i+1))
+		if err != nil {
+			return nil, err
+		}
+		part.Parts = append(part.Parts, child)
+	}
+	if i >= len(list) {
+		return nil, fmt.Errorf("multipart %s has no subtype", section)
+	}
+	part.Subtype = strings.ToLower(list[i].String())
+	rest := list[i+1:]
+	if len(rest) > 0 {
+		part.Params = parseBodyParams(rest[0])
+	}
+	parseBodyExtension(part, rest, 1)
+	return part, nil
+}
+
+func parseSinglePart(list []Field, section string) (*BodyPart, error) {
+	if len(list) < 7 {
+		return nil, fmt.Errorf("body part %s has %d fields, expected at least 7", section, len(list))
+	}
+	part := &BodyPart{
+		Type:        strings.ToLower(list[0].String()),
+		Subtype:     strings.ToLower(list[1].String()),
+		Params:      parseBodyParams(list[2]),
+		ID:          list[3].String(),
+		Description: list[4].String(),
+		Encoding:    strings.ToLower(list[5].String()),
+		Section:     section,
+	}
+	// Some servers send a size that does not fit, treat it as unknown
+	// rather than rejecting the whole message.
+	part.Size, _ = list[6].Uint32()
+	rest := list[7:]
+	switch {
+	case part.Type == "message" && (part.Subtype == "rfc822" || part.Subtype == "global") && len(rest) >= 3:
+		innerSection := section
+		if len(rest[1].List) > 0 && rest[1].List[0].Type != ListField {
+			innerSection = childSection(section, 1)
+		}
+		message, err := parseBodyPart(rest[1], innerSection)
+		if err != nil {
+			return nil, err
+		}
+		part.Message = message
+		part.Lines, _ = rest[2].Uint32()
+		rest = rest[3:]
+	case part.Type == "text" && len(rest) >= 1:
+		part.Lines, _ = rest[0].Uint32()
+		rest = rest[1:]
+	}
+	// The first extension field of a single part is the MD5, which is not kept.
+	parseBodyExtension(part, rest, 1)
+	return part, nil
+}
+
+// parseBodyExtension reads disposition and language, which follow the
+// skip fields that differ between single and multipart bodies.
+func parseBodyExtension(part *BodyPart, rest []Field, skip int) {
+	if len(rest) <= skip {
+		return
+	}
+	rest = rest[skip:]
+	if disposition := rest[0]; disposition.Type == ListField && len(disposition.List) > 0 {
+		part.Disposition = strings.ToLower(disposition.List[0].String())
+		if len(disposition.List) > 1 {
+			part.DispositionParams = parseBodyParams(disposition.List[1])
+		}
+	}
+	if len(rest) < 2 {
+		return
+	}
+	switch language := rest[1]; language.Type {
+	case ListField:
+		for _, item := range language.List {
+			part.Language = append(part.Language, item.String())
+		}
+	case StringField, AtomField:
+		part.Language = []string{language.String()}
+	}
+}
+
+func parseBodyParams(field Field) map[string]string {
+	if field.Type != ListField || len(field.List) == 0 {
+		return nil
+	}
+	params := map[string]string{}
+	for i := 0; i+1 < len(field.List); i += 2 {
+		params[strings.ToLower(field.List[i].String())] = field.List[i+1].String()
+	}
+	return params
+}
+
+func childSection(section string, index int) string {
+	if section == "" {
+		return strconv.Itoa(index)
+	}
+	return section + "." + strconv.Itoa(index)
+}
+
+func (p *BodyPart) MediaType() string {
+	return p.Type + "/" + p.Subtype
+}
+
+func (p *BodyPart) Filename() string {
+	name := p.DispositionParams["filename"]
+	if name == "" {
+		name = p.Params["name"]
+	}
+	decoded, err := DecodeMimeContent(name)
+	if err != nil {
+		return name
+	}
+	return strings.TrimSpace(decoded)
+}
+
+// IsAttachment reports whether the part is meant to be saved rather than
+// read: anything marked as an attachment, and named non-text parts.
+func (p *BodyPart) IsAttachment() bool {
+	if p.Type == "multipart" {
+		return false
+	}
+	if p.Disposition == "attachment" {
+		return true
+	}
+	return p.Type != "text" && p.Filename() != ""
+}
+
+// TextPart picks the part to show in the reading pane, preferring
+// text/plain over text/html and never descending into attached messages.
+func (p *BodyPart) TextPart() *BodyPart {
+	if plain := p.findText("plain"); plain != nil {
+		return plain
+	}
+	return p.findText("html")
+}
+
+func (p *BodyPart) findText(subtype string) *BodyPart {
+	if p.Type == "multipart" {
+		for _, child := range p.Parts {
+			if found := child.findText(subtype); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	if p.Type == "text" && p.Subtype == subtype && !p.IsAttachment() {
+		return p
+	}
+	return nil
+}
+
+func (p *BodyPart) Attachments() []*BodyPart {
+	attachments := []*BodyPart{}
+	if p.IsAttachment() {
+		return append(attachments, p)
+	}
+	for _, child := range p.Parts {
+		attachments = append(attachments, child.Attachments()...)
+	}
+	return attachments
+}
+
+// EmailStructure decodes the part tree cached with an email. It returns nil
+// when the email was cached before structures were fetched.
+func EmailStructure(email repository.Email) (*BodyPart, error) {
+	if email.BodyStructure == "" {
+		return nil, nil
+	}
+	part := &BodyPart{}
+	if err := json.Unmarshal([]byte(email.BodyStructure), part); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
@@ -0,0 +1,199 @@
+package mails
+
+import (
+	"bufio"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fetchItem parses a FETCH response carrying items and returns the one
+// called name.
+func fetchItem(t *testing.T, name, items string) Field {
+	t.Helper()
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader("* 1 FETCH (" + items + ")\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, ok := fetchItems(resp.Fields)[name]
+	if !ok {
+		t.Fatalf("no %s in %s", name, items)
+	}
+	return item
+}
+
+const (
+	plainPart = `("text" "plain" ("charset" "utf-8") NIL NIL "7bit" 12 1)`
+	htmlPart  = `("text" "html" ("charset" "utf-8") NIL NIL "quoted-printable" 40 2)`
+)
+
+var (
+	plainBody = &BodyPart{Type: "text", Subtype: "plain", Params: map[string]string{"charset": "utf-8"}, Encoding: "7bit", Size: 12, Lines: 1}
+	htmlBody  = &BodyPart{Type: "text", Subtype: "html", Params: map[string]string{"charset": "utf-8"}, Encoding: "quoted-printable", Size: 40, Lines: 2}
+)
+
+// at returns a copy of part numbered section.
+func at(part *BodyPart, section string) *BodyPart {
+	copied := *part
+	copied.Section = section
+	return &copied
+}
+
+func TestParseBodyStructure(t *testing.T) {
+	tests := []struct {
+		name      string
+		structure string
+		want      *BodyPart
+	}{
+		{
+			name:      "single part",
+			structure: plainPart,
+			want:      at(plainBody, "1"),
+		},
+		{
+			name:      "non-multipart message",
+			structure: `("application" "pdf" ("name" "a.pdf") "<id@x>" "Report" "base64" 1024)`,
+			want: &BodyPart{
+				Type: "application", Subtype: "pdf", Params: map[string]string{"name": "a.pdf"},
+				ID: "<id@x>", Description: "Report", Encoding: "base64", Size: 1024, Section: "1",
+			},
+		},
+		{
+			name:      "alternative",
+			structure: "(" + plainPart + htmlPart + ` "alternative" ("boundary" "b1") NIL NIL)`,
+			want: &BodyPart{
+				Type: "multipart", Subtype: "alternative", Params: map[string]string{"boundary": "b1"},
+				Parts: []*BodyPart{at(plainBody, "1"), at(htmlBody, "2")},
+			},
+		},
+		{
+			name:      "single part extension fields",
+			structure: `("text" "plain" NIL NIL NIL "8bit" 5 1 "md5sum" ("inline" ("filename" "note.txt")) ("en" "de") "http://example.org/loc")`,
+			want: &BodyPart{
+				Type: "text", Subtype: "plain", Encoding: "8bit", Size: 5, Lines: 1, Section: "1",
+				Disposition: "inline", DispositionParams: map[string]string{"filename": "note.txt"},
+				Language: []string{"en", "de"},
+			},
+		},
+		{
+			name:      "multipart extension fields",
+			structure: "(" + plainPart + ` "mixed" ("boundary" "b2") ("inline" NIL) "en" "http://example.org/loc")`,
+			want: &BodyPart{
+				Type: "multipart", Subtype: "mixed", Params: map[string]string{"boundary": "b2"},
+				Disposition: "inline", Language: []string{"en"},
+				Parts: []*BodyPart{at(plainBody, "1")},
+			},
+		},
+		{
+			name: "attached message with a single part",
+			structure: "(" + plainPart +
+				`("message" "rfc822" NIL NIL NIL "7bit" 300 ("Mon, 2 Jan 2006 15:04:05 +0000" "inner" NIL NIL NIL NIL NIL NIL NIL NIL) ` +
+				plainPart + ` 10 NIL ("attachment" ("filename" "fwd.eml")) NIL)` +
+				` "mixed" ("boundary" "b3"))`,
+			want: &BodyPart{
+				Type: "multipart", Subtype: "mixed", Params: map[string]string{"boundary": "b3"},
+				Parts: []*BodyPart{
+					at(plainBody, "1"),
+					{
+						Type: "message", Subtype: "rfc822", Encoding: "7bit", Size: 300, Lines: 10, Section: "2",
+						Disposition: "attachment", DispositionParams: map[string]string{"filename": "fwd.eml"},
+						Message: at(plainBody, "2.1"),
+					},
+				},
+			},
+		},
+		{
+			name: "attached multipart message",
+			structure: "(" + plainPart +
+				`("message" "rfc822" NIL NIL NIL "7bit" 600 ("Mon, 2 Jan 2006 15:04:05 +0000" "inner" NIL NIL NIL NIL NIL NIL NIL NIL) ` +
+				"(" + plainPart + htmlPart + ` "alternative" ("boundary" "inner")) 20)` +
+				` "mixed" ("boundary" "b4"))`,
+			want: &BodyPart{
+				Type: "multipart", Subtype: "mixed", Params: map[string]string{"boundary": "b4"},
+				Parts: []*BodyPart{
+					at(plainBody, "1"),
+					{
+						Type: "message", Subtype: "rfc822", Encoding: "7bit", Size: 600, Lines: 20, Section: "2",
+						Message: &BodyPart{
+							Type: "multipart", Subtype: "alternative", Params: map[string]string{"boundary": "inner"}, Section: "2",
+							Parts: []*BodyPart{at(plainBody, "2.1"), at(htmlBody, "2.2")},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "nested multipart",
+			structure: "((" + plainPart + htmlPart + ` "alternative")` +
+				`("image" "png" ("name" "a.png") NIL NIL "base64" 99) "related")`,
+			want: &BodyPart{
+				Type: "multipart", Subtype: "related",
+				Parts: []*BodyPart{
+					{Type: "multipart", Subtype: "alternative", Section: "1", Parts: []*BodyPart{at(plainBody, "1.1"), at(htmlBody, "1.2")}},
+					{Type: "image", Subtype: "png", Params: map[string]string{"name": "a.png"}, Encoding: "base64", Size: 99, Section: "2"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBodyStructure(fetchItem(t, "BODYSTRUCTURE", "BODYSTRUCTURE "+tt.structure))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %s\nwant %s", dumpPart(got), dumpPart(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseBodyStructureErrors(t *testing.T) {
+	tests := map[string]string{
+		"not a list":      `NIL`,
+		"empty":           `()`,
+		"short part":      `("text" "plain" NIL NIL NIL "7bit")`,
+		"no subtype":      "(" + plainPart + plainPart + ")",
+		"malformed child": `(() "mixed")`,
+	}
+	for name, structure := range tests {
+		t.Run(name, func(t *testing.T) {
+			if part, err := ParseBodyStructure(fetchItem(t, "BODYSTRUCTURE", "BODYSTRUCTURE "+structure)); err == nil {
+				t.Errorf("got %s, want an error", dumpPart(part))
+			}
+		})
+	}
+}
+
+func TestBodyPartSelection(t *testing.T) {
+	structure := "(" +
+		"(" + plainPart + htmlPart + ` "alternative")` +
+		`("text" "plain" NIL NIL NIL "7bit" 5 1 NIL ("attachment" ("filename" "notes.txt")))` +
+		`("application" "pdf" ("name" "=?utf-8?q?r=C3=A9sum=C3=A9.pdf?=") NIL NIL "base64" 99)` +
+		`("message" "rfc822" NIL NIL NIL "7bit" 300 (NIL NIL NIL NIL NIL NIL NIL NIL NIL NIL) ` + plainPart + ` 10)` +
+		` "mixed")`
+	part, err := ParseBodyStructure(fetchItem(t, "BODYSTRUCTURE", "BODYSTRUCTURE "+structure))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := part.TextPart(); text == nil || text.Section != "1.1" {
+		t.Errorf("TextPart = %s, want section 1.1", dumpPart(text))
+	}
+	names := []string{}
+	for _, attachment := range part.Attachments() {
+		names = append(names, attachment.Section+" "+attachment.Filename())
+	}
+	if want := []string{"2 notes.txt", "3 résumé.pdf"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("attachments = %q, want %q", names, want)
+	}
+}
+
+// dumpPart shows a part tree in failure messages.
+func dumpPart(part *BodyPart) string {
+	data, err := json.Marshal(part)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
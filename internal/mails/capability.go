@@ -0,0 +1,85 @@
+package mails
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type Capability string
+
+const (
+	CapIMAP4rev1     Capability = "IMAP4REV1"
+	CapIMAP4rev2     Capability = "IMAP4REV2"
+	CapCondStore     Capability = "CONDSTORE"
+	CapQResync       Capability = "QRESYNC"
+	CapEnable        Capability = "ENABLE"
+	CapIdle          Capability = "IDLE"
+	CapUIDPlus       Capability = "UIDPLUS"
+	CapMove          Capability = "MOVE"
+	CapSpecialUse    Capability = "SPECIAL-USE"
+	CapListStatus    Capability = "LIST-STATUS"
+	CapListExtended  Capability = "LIST-EXTENDED"
+	CapSASLIR        Capability = "SASL-IR"
+	CapStartTLS      Capability = "STARTTLS"
+	CapLoginDisabled Capability = "LOGINDISABLED"
+)
+
+type CapabilitySet map[Capability]bool
+
+func NewCapabilitySet(fields []Field) CapabilitySet {
+	caps := CapabilitySet{}
+	for _, field := range fields {
+		if field.Type == AtomField {
+			caps[Capability(strings.ToUpper(field.Value))] = true
+		}
+	}
+	return caps
+}
+
+func (c CapabilitySet) Has(capability Capability) bool {
+	return c[capability]
+}
+
+func (c CapabilitySet) AuthMechanisms() []string {
+	mechanisms := []string{}
+	for capability := range c {
+		if mechanism, ok := strings.CutPrefix(string(capability), "AUTH="); ok {
+			mechanisms = append(mechanisms, mechanism)
+		}
+	}
+	return mechanisms
+}
+
+func (m *MailClient) ReadGreeting(ctx context.Context) error {
+	m.command = "greeting"
+	resp, err := m.readResponse(ctx)
+	if err != nil {
+		return err
+	}
+	switch resp.Name {
+	case "OK", "PREAUTH":
+		m.updateCapabilities(resp)
+		return nil
+	default:
+		return fmt.Errorf("server rejected connection: %s %s", resp.Name, resp.Text)
+	}
+}
+
+func (m *MailClient) Capability(ctx context.Context) error {
+	code, err := m.SendMessage(ctx, "CAPABILITY", "")
+	if err != nil {
+		return err
+	}
+	_, _, err = m.ParseIMAPContent(ctx, code)
+	return err
+}
+
+func (m *MailClient) updateCapabilities(resp *Response) {
+	switch {
+	case resp.Name == "CAPABILITY":
+		m.Capabilities = NewCapabilitySet(resp.Fields)
+	case resp.Code == "CAPABILITY":
+		m.Capabilities = NewCapabilitySet(resp.CodeArgs)
+	}
+}
@@ -0,0 +1,211 @@
+package mails
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+type SecurityMode string
+
+const (
+	SecurityTLS      SecurityMode = "tls"
+	SecuritySTARTTLS SecurityMode = "starttls"
+	SecurityPlain    SecurityMode = "plain"
+)
+
+// defaultTimeout bounds how long the server may stay silent during a
+// command when IMAP_TIMEOUT is not set.
+const defaultTimeout = time.Minute
+
+type ConnConfig struct {
+	Address           string
+	Security          SecurityMode
+	TLSConfig         *tls.Config
+	AllowInsecureAuth bool
+	Timeout           time.Duration
+}
+
+// ConnConfigFromEnv builds the connection settings from IMAP_SECURITY,
+// IMAP_CA_FILE, IMAP_CLIENT_CERT, IMAP_CLIENT_KEY, IMAP_SERVER_NAME,
+// IMAP_ALLOW_INSECURE_AUTH and IMAP_TIMEOUT.
+func ConnConfigFromEnv(address string) (*ConnConfig, error) {
+	security := SecurityMode(strings.ToLower(os.Getenv("IMAP_SECURITY")))
+	switch security {
+	case "":
+		security = SecurityTLS
+	case SecurityTLS, SecuritySTARTTLS, SecurityPlain:
+	default:
+		return nil, fmt.Errorf("unknown IMAP_SECURITY %q, expected tls, starttls or plain", security)
+	}
+	tlsConfig, err := NewTLSConfig(address, os.Getenv("IMAP_SERVER_NAME"), os.Getenv("IMAP_CA_FILE"), os.Getenv("IMAP_CLIENT_CERT"), os.Getenv("IMAP_CLIENT_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	allowInsecureAuth := os.Getenv("IMAP_ALLOW_INSECURE_AUTH")
+	timeout := defaultTimeout
+	if value := os.Getenv("IMAP_TIMEOUT"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IMAP_TIMEOUT %q: %w", value, err)
+		}
+	}
+	return &ConnConfig{
+		Address:           address,
+		Security:          security,
+		TLSConfig:         tlsConfig,
+		AllowInsecureAuth: allowInsecureAuth == "1" || strings.EqualFold(allowInsecureAuth, "true"),
+		Timeout:           timeout,
+	}, nil
+}
+
+func NewTLSConfig(address, serverName, caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Dial opens the transport. For STARTTLS the connection stays in plaintext
+// until StartTLS is called after the greeting.
+func Dial(ctx context.Context, config *ConnConfig) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if config.Security == SecurityTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config.TLSConfig}
+		return tlsDialer.DialContext(ctx, "tcp", config.Address)
+	}
+	return dialer.DialContext(ctx, "tcp", config.Address)
+}
+
+func (m *MailClient) StartTLS(ctx context.Context, config *tls.Config) error {
+	if !m.Capabilities.Has(CapStartTLS) {
+		return errors.New("server does not advertise STARTTLS")
+	}
+	code, err := m.SendMessage(ctx, "STARTTLS", "")
+	if err != nil {
+		return err
+	}
+	err = m.ReadMessage(ctx, code)
+	if err != nil {
+		return err
+	}
+	if m.Reader.Buffered() > 0 {
+		return errors.New("server sent data before the TLS handshake")
+	}
+	recorder, recording := m.Conn.(*Recorder)
+	base := m.Conn
+	if recording {
+		base = recorder.conn
+	}
+	conn, ok := base.(net.Conn)
+	if !ok {
+		return errors.New("STARTTLS needs a network connection")
+	}
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return m.ioError(ctx, err)
+	}
+	// A transcript keeps recording the plain text above TLS.
+	if recording {
+		recorder.conn = tlsConn
+	} else {
+		m.Conn = tlsConn
+	}
+	m.Reader = bufio.NewReader(m.Conn)
+	m.Writer = bufio.NewWriter(m.Conn)
+	m.Capabilities = nil
+	return m.Capability(ctx)
+}
+
+// Close closes the connection if it can be closed.
+func (m *MailClient) Close() error {
+	if closer, ok := m.Conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// deadlineConn is a connection whose reads and writes can be bounded, such
+// as a net.Conn or a Recorder around one. Other connections are not.
+type deadlineConn interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// watch bounds the next read, or write, by the ctx deadline and m.Timeout,
+// whichever comes first, and cuts it short when ctx is cancelled. The
+// returned stop releases the cancellation hook.
+func (m *MailClient) watch(ctx context.Context, write bool) (func() bool, error) {
+	conn, ok := m.Conn.(deadlineConn)
+	if !ok {
+		return func() bool { return false }, ctx.Err()
+	}
+	setDeadline := conn.SetReadDeadline
+	if write {
+		setDeadline = conn.SetWriteDeadline
+	}
+	deadline := time.Time{}
+	if m.Timeout > 0 {
+		deadline = time.Now().Add(m.Timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	if err := setDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		setDeadline(time.Now())
+	})
+	// A cancellation that raced the deadline above would be lost.
+	if err := ctx.Err(); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
+
+func (m *MailClient) IsSecure() bool {
+	conn := m.Conn
+	if recorder, ok := conn.(*Recorder); ok {
+		conn = recorder.conn
+	}
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
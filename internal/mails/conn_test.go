@@ -0,0 +1,126 @@
+package mails
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const tlsServerName = "imap.example.org"
+
+// tlsConfigs returns a server config with a self-signed certificate for
+// tlsServerName and a client config that trusts it.
+func tlsConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: tlsServerName},
+		DNSNames:              []string{tlsServerName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: roots, ServerName: tlsServerName}
+	return server, client
+}
+
+func TestStartTLS(t *testing.T) {
+	for _, bare := range []bool{false, true} {
+		name := "greeting capabilities"
+		if bare {
+			name = "bare greeting"
+		}
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t)
+			serverTLS, clientTLS := tlsConfigs(t)
+			srv.SetTLSConfig(serverTLS)
+			srv.SetBareGreeting(bare)
+			appendMail(t, srv, "INBOX", "over tls")
+			conn, err := srv.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &ConnConfig{Security: SecuritySTARTTLS, TLSConfig: clientTLS}
+			client, err := openClient(t, conn, dbPath(t), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !client.IsSecure() {
+				t.Error("connection is not encrypted")
+			}
+			if client.Capabilities.Has(CapStartTLS) {
+				t.Error("capabilities were not refreshed after STARTTLS")
+			}
+			commands := srv.Commands()
+			starttls := slices.Index(commands, "STARTTLS")
+			login := slices.IndexFunc(commands, func(command string) bool { return strings.HasPrefix(command, "LOGIN") })
+			if starttls < 0 || login < starttls {
+				t.Errorf("commands = %v, want STARTTLS before LOGIN", commands)
+			}
+			if bare && (starttls == 0 || commands[0] != "CAPABILITY") {
+				t.Errorf("commands = %v, want CAPABILITY before STARTTLS", commands)
+			}
+			if got := subjects(client.Mails("INBOX")); !slices.Equal(got, []string{"over tls"}) {
+				t.Errorf("subjects = %v", got)
+			}
+		})
+	}
+}
+
+func TestStartTLSUnavailable(t *testing.T) {
+	srv := newServer(t)
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientTLS := tlsConfigs(t)
+	config := &ConnConfig{Security: SecuritySTARTTLS, TLSConfig: clientTLS}
+	if _, err := openClient(t, conn, dbPath(t), config); err == nil {
+		t.Fatal("STARTTLS without server support succeeded")
+	}
+	if commands := srv.Commands(); hasCommand(commands, "STARTTLS") || hasCommand(commands, "LOGIN") {
+		t.Errorf("commands = %v", commands)
+	}
+}
+
+func TestInsecureAuth(t *testing.T) {
+	srv := newServer(t)
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ConnConfig{Security: SecurityPlain}
+	if _, err := openClient(t, conn, dbPath(t), config); !errors.Is(err, ErrInsecureAuth) {
+		t.Fatalf("err = %v, want ErrInsecureAuth", err)
+	}
+	if commands := srv.Commands(); hasCommand(commands, "LOGIN") || hasCommand(commands, "AUTHENTICATE") {
+		t.Errorf("sent credentials in plaintext: %v", commands)
+	}
+}
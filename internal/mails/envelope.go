@@ -0,0 +1,91 @@
+package mails
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const internalDateLayout = "_2-Jan-2006 15:04:05 -0700"
+
+func ParseEnvelope(field Field) (*Envelope, error) {
+	if field.Type != ListField || len(field.List) < 10 {
+		return nil, errors.New("ENVELOPE must be a list of 10 fields")
+	}
+	list := field.List
+	return &Envelope{
+		Date:      list[0].String(),
+		Subject:   decodeHeaderValue(list[1].String()),
+		From:      parseAddressList(list[2]),
+		Sender:    parseAddressList(list[3]),
+		ReplyTo:   parseAddressList(list[4]),
+		To:        parseAddressList(list[5]),
+		Cc:        parseAddressList(list[6]),
+		Bcc:       parseAddressList(list[7]),
+		InReplyTo: list[8].String(),
+		MessageID: list[9].String(),
+	}, nil
+}
+
+// parseAddressList drops RFC 2822 group markers, which the server sends as
+// addresses without a host.
+func parseAddressList(field Field) []Address {
+	addresses := []Address{}
+	for _, item := range field.List {
+		if item.Type != ListField || len(item.List) < 4 || item.List[3].IsNil() {
+			continue
+		}
+		addresses = append(addresses, Address{
+			Name:    decodeHeaderValue(item.List[0].String()),
+			Mailbox: item.List[2].String(),
+			Host:    item.List[3].String(),
+		})
+	}
+	return addresses
+}
+
+// decodeHeaderValue decodes encoded words, keeping the raw text when the
+// encoding is broken instead of failing the whole sync.
+func decodeHeaderValue(value string) string {
+	decoded, err := DecodeMimeContent(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func (a Address) Email() string {
+	if a.Host == "" {
+		return a.Mailbox
+	}
+	return a.Mailbox + "@" + a.Host
+}
+
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Email()
+	}
+	return a.Name + " <" + a.Email() + ">"
+}
+
+func formatAddressList(addresses []Address) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, address.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// ParseDate parses the envelope date, falling back to the INTERNALDATE and then
+// to the zero time when the Date header is missing or malformed.
+func (e *Envelope) ParseDate(internalDate time.Time) time.Time {
+	if date, err := mail.ParseDate(strings.TrimSpace(e.Date)); err == nil {
+		return date
+	}
+	return internalDate
+}
+
+func parseInternalDate(value string) (time.Time, error) {
+	return time.Parse(internalDateLayout, value)
+}
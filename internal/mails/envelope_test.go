@@ -0,0 +1,128 @@
+package mails
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope string
+		want     *Envelope
+	}{
+		{
+			name: "full",
+			envelope: `("Mon, 2 Jan 2006 15:04:05 -0700" "=?utf-8?q?h=C3=A9llo?=" ` +
+				`(("=?utf-8?b?7ZWc6riA?=" NIL "ann" "example.org")) (("Ann" NIL "ann" "example.org")) NIL ` +
+				`(("Bob" NIL "bob" "example.org")("Carol" NIL "carol" "example.org")) NIL NIL ` +
+				`"<parent@example.org>" "<hello@example.org>")`,
+			want: &Envelope{
+				Date:      "Mon, 2 Jan 2006 15:04:05 -0700",
+				Subject:   "héllo",
+				From:      []Address{{Name: "한글", Mailbox: "ann", Host: "example.org"}},
+				Sender:    []Address{{Name: "Ann", Mailbox: "ann", Host: "example.org"}},
+				ReplyTo:   []Address{},
+				To:        []Address{{Name: "Bob", Mailbox: "bob", Host: "example.org"}, {Name: "Carol", Mailbox: "carol", Host: "example.org"}},
+				Cc:        []Address{},
+				Bcc:       []Address{},
+				InReplyTo: "<parent@example.org>",
+				MessageID: "<hello@example.org>",
+			},
+		},
+		{
+			name: "group markers",
+			envelope: `(NIL "team" NIL NIL NIL ` +
+				`((NIL NIL "team" NIL)(NIL NIL "bob" "example.org")(NIL NIL "carol" "example.org")(NIL NIL NIL NIL)` +
+				`(NIL NIL "undisclosed-recipients" NIL)(NIL NIL NIL NIL)) NIL NIL NIL NIL)`,
+			want: &Envelope{
+				Subject: "team",
+				From:    []Address{},
+				Sender:  []Address{},
+				ReplyTo: []Address{},
+				To:      []Address{{Mailbox: "bob", Host: "example.org"}, {Mailbox: "carol", Host: "example.org"}},
+				Cc:      []Address{},
+				Bcc:     []Address{},
+			},
+		},
+		{
+			name:     "NIL host",
+			envelope: `(NIL NIL ((NIL NIL "root" NIL)) NIL NIL (("Bob" NIL "bob" "example.org")) NIL NIL NIL NIL)`,
+			want: &Envelope{
+				From:    []Address{},
+				Sender:  []Address{},
+				ReplyTo: []Address{},
+				To:      []Address{{Name: "Bob", Mailbox: "bob", Host: "example.org"}},
+				Cc:      []Address{},
+				Bcc:     []Address{},
+			},
+		},
+		{
+			name:     "broken encoded word",
+			envelope: `(NIL "=?utf-8?b?@@@?=" NIL NIL NIL NIL NIL NIL NIL NIL)`,
+			want: &Envelope{
+				Subject: "=?utf-8?b?@@@?=",
+				From:    []Address{},
+				Sender:  []Address{},
+				ReplyTo: []Address{},
+				To:      []Address{},
+				Cc:      []Address{},
+				Bcc:     []Address{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEnvelope(fetchItem(t, "ENVELOPE", "ENVELOPE "+tt.envelope))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvelopeErrors(t *testing.T) {
+	for _, envelope := range []string{`NIL`, `("date" "subject")`} {
+		if got, err := ParseEnvelope(fetchItem(t, "ENVELOPE", "ENVELOPE "+envelope)); err == nil {
+			t.Errorf("ParseEnvelope(%s) = %+v, want an error", envelope, got)
+		}
+	}
+}
+
+func TestAddressString(t *testing.T) {
+	addresses := []Address{
+		{Name: "Ann", Mailbox: "ann", Host: "example.org"},
+		{Mailbox: "bob", Host: "example.org"},
+		{Mailbox: "root"},
+	}
+	if got, want := formatAddressList(addresses), "Ann <ann@example.org>, bob@example.org, root"; got != want {
+		t.Errorf("formatAddressList = %q, want %q", got, want)
+	}
+}
+
+func TestEnvelopeParseDate(t *testing.T) {
+	internalDate, err := parseInternalDate(" 3-Jan-2006 09:00:00 +0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2006, 1, 3, 9, 0, 0, 0, time.UTC); !internalDate.Equal(want) {
+		t.Fatalf("INTERNALDATE = %v, want %v", internalDate, want)
+	}
+	tests := map[string]time.Time{
+		"Mon, 2 Jan 2006 15:04:05 -0700": time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC),
+		" Mon, 2 Jan 2006 15:04:05 GMT ": time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		"":                               internalDate,
+		"yesterday":                      internalDate,
+		"Mon, 32 Jan 2006 15:04:05":      internalDate,
+	}
+	for date, want := range tests {
+		envelope := &Envelope{Date: date}
+		if got := envelope.ParseDate(internalDate); !got.Equal(want) {
+			t.Errorf("ParseDate(%q) = %v, want %v", date, got, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package mails
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Response codes (RFC 3501, RFC 5530) that callers may act on.
+const (
+	CodeAlert                = "ALERT"
+	CodeAuthenticationFailed = "AUTHENTICATIONFAILED"
+	CodeTryCreate            = "TRYCREATE"
+	CodeOverQuota            = "OVERQUOTA"
+	CodeUnavailable          = "UNAVAILABLE"
+)
+
+// alertBuffer is how many alerts are kept until they are read from Alerts.
+const alertBuffer = 16
+
+// ErrConnectionBroken is returned by every command once an earlier command
+// failed midway, leaving the connection in an unknown state. Nothing more is
+// sent on such a connection.
+var ErrConnectionBroken = errors.New("IMAP connection is broken")
+
+// ErrServerClosed matches the StatusError returned when the server
+// announces with BYE that it is closing the connection.
+var ErrServerClosed = errors.New("server closed the connection")
+
+// ErrInsecureAuth is returned by Login instead of sending credentials over a
+// connection without TLS, unless AllowInsecureAuth is set.
+var ErrInsecureAuth = errors.New("refusing to send credentials over an unencrypted connection, set IMAP_ALLOW_INSECURE_AUTH=1 to override")
+
+// StatusError is a command refused with a tagged NO or BAD, or a connection
+// closed with BYE. Code and CodeArgs hold the response code, such as
+// AUTHENTICATIONFAILED or TRYCREATE, and Text the server's explanation.
+type StatusError struct {
+	Command  string
+	Status   string
+	Code     string
+	CodeArgs []Field
+	Text     string
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("IMAP %s: %s %s", e.Command, e.Status, e.Text)
+	}
+	return fmt.Sprintf("IMAP %s: %s [%s] %s", e.Command, e.Status, e.Code, e.Text)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrServerClosed && e.Status == "BYE"
+}
+
+// HasCode reports whether err is a StatusError with the response code.
+func HasCode(err error, code string) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == code
+}
+
+func (m *MailClient) statusError(resp *Response) error {
+	return &StatusError{Command: m.command, Status: resp.Name, Code: resp.Code, CodeArgs: resp.CodeArgs, Text: resp.Text}
+}
+
+// Alerts delivers the text of every [ALERT] the server sends, which RFC 3501
+// requires to be shown to the user. Alerts that arrive while alertBuffer
+// alerts are waiting are dropped; the trace still has them.
+func (m *MailClient) Alerts() <-chan string {
+	return m.alerts
+}
+
+func (m *MailClient) alert(text string) {
+	select {
+	case m.alerts <- text:
+	default:
+	}
+}
+
+// TimeoutError is returned when a command does not complete before its
+// context deadline or the client Timeout. It wraps
+// context.DeadlineExceeded or os.ErrDeadlineExceeded.
+type TimeoutError struct {
+	Command string
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("IMAP %s timed out", e.Command)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// contextError reports a cancelled context as its own error and an expired
+// deadline as a TimeoutError.
+func (m *MailClient) contextError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return ctx.Err()
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &TimeoutError{Command: m.command, Err: ctx.Err()}
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		// The connection deadline may fire just before the context notices
+		// its own.
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			err = context.DeadlineExceeded
+		}
+		return &TimeoutError{Command: m.command, Err: err}
+	}
+	return err
+}
+
+// ioError turns a failed read or write into the error returned to the
+// caller and marks the connection as broken.
+func (m *MailClient) ioError(ctx context.Context, err error) error {
+	err = m.contextError(ctx, err)
+	m.breakConn(err)
+	return err
+}
+
+// breakConn marks the connection as broken by err unless it already is.
+func (m *MailClient) breakConn(err error) {
+	m.brokenMu.Lock()
+	defer m.brokenMu.Unlock()
+	if m.broken == nil {
+		m.broken = fmt.Errorf("%w: %w", ErrConnectionBroken, err)
+	}
+}
+
+// Err returns the error that broke the connection, or nil while it is
+// usable.
+func (m *MailClient) Err() error {
+	m.brokenMu.Lock()
+	defer m.brokenMu.Unlock()
+	return m.broken
+}
@@ -0,0 +1,201 @@
+package mails
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/milkymilky0116/jellyfish/internal/db"
+	"github.com/milkymilky0116/jellyfish/internal/imaptest"
+)
+
+func noop(ctx context.Context, client *MailClient) error {
+	code, err := client.SendMessage(ctx, "NOOP", "")
+	if err != nil {
+		return err
+	}
+	return client.ReadMessage(ctx, code)
+}
+
+func TestStatusError(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+
+	srv.Inject(imaptest.Failure{
+		Command:  "SELECT",
+		Untagged: []string{"* OK [ALERT] Maintenance tonight"},
+		Response: "NO [TRYCREATE] No such mailbox",
+	})
+	err := client.SelectMailBox(ctx, "INBOX")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("err = %v, want a StatusError", err)
+	}
+	if statusErr.Command != "SELECT" || statusErr.Status != "NO" || statusErr.Code != CodeTryCreate || statusErr.Text != "No such mailbox" {
+		t.Errorf("err = %+v", statusErr)
+	}
+	if !HasCode(err, CodeTryCreate) || HasCode(err, CodeAlert) {
+		t.Errorf("HasCode is wrong for %v", err)
+	}
+	select {
+	case alert := <-client.Alerts():
+		if alert != "Maintenance tonight" {
+			t.Errorf("alert = %q", alert)
+		}
+	default:
+		t.Error("no alert")
+	}
+	// A NO leaves the connection usable.
+	if err := client.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusErrorCodeArgs(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+	srv.Inject(imaptest.Failure{Command: "NOOP", Response: "BAD [BADCHARSET (UTF-8 US-ASCII)] Unknown charset"})
+	err := noop(context.Background(), client)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != "BAD" || statusErr.Code != "BADCHARSET" {
+		t.Fatalf("err = %v", err)
+	}
+	if len(statusErr.CodeArgs) != 1 || len(statusErr.CodeArgs[0].List) != 2 {
+		t.Errorf("CodeArgs = %#v", statusErr.CodeArgs)
+	}
+}
+
+func TestBye(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+
+	srv.Inject(imaptest.Failure{
+		Command:  "NOOP",
+		Untagged: []string{"* BYE [UNAVAILABLE] Restarting"},
+		Close:    true,
+	})
+	err := noop(ctx, client)
+	if !errors.Is(err, ErrServerClosed) || !HasCode(err, CodeUnavailable) {
+		t.Fatalf("err = %v, want ErrServerClosed with UNAVAILABLE", err)
+	}
+	if !errors.Is(client.Err(), ErrConnectionBroken) {
+		t.Fatalf("Err() = %v", client.Err())
+	}
+	n := len(srv.Commands())
+	if err := noop(ctx, client); !errors.Is(err, ErrConnectionBroken) {
+		t.Errorf("NOOP on a broken connection = %v", err)
+	}
+	if got := sentSince(srv, n); len(got) != 0 {
+		t.Errorf("sent %v on a broken connection", got)
+	}
+}
+
+func TestDroppedConnection(t *testing.T) {
+	srv := newServer(t)
+	appendMail(t, srv, "INBOX", "one")
+	client := connect(t, srv, dbPath(t))
+
+	srv.Inject(imaptest.Failure{
+		Command:  "UID FETCH",
+		Untagged: []string{"* 1 FETCH (UID 1 FLAGS ("},
+		Close:    true,
+	})
+	if _, err := client.FetchSection(context.Background(), 1, "1"); err == nil {
+		t.Fatal("fetch on a dropped connection succeeded")
+	}
+	if !errors.Is(client.Err(), ErrConnectionBroken) {
+		t.Errorf("Err() = %v", client.Err())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+	client.Timeout = 100 * time.Millisecond
+
+	srv.Inject(imaptest.Failure{Command: "NOOP", Response: "OK NOOP completed", Delay: time.Second})
+	start := time.Now()
+	err := noop(context.Background(), client)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Command != "NOOP" || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("err = %v, want a NOOP TimeoutError", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("timed out after %v", elapsed)
+	}
+	if !errors.Is(client.Err(), ErrConnectionBroken) {
+		t.Errorf("Err() = %v", client.Err())
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+
+	srv.Inject(imaptest.Failure{Command: "NOOP", Response: "OK NOOP completed", Delay: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := noop(ctx, client)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+
+	// Nothing is sent once ctx is done, so the connection stays usable.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n := len(srv.Commands())
+	if err := client.SelectMailBox(ctx, "INBOX"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if got := sentSince(srv, n); len(got) != 0 || client.Err() != nil {
+		t.Fatalf("sent %v, Err() = %v", got, client.Err())
+	}
+
+	// Cancelling a command in flight leaves the connection mid-response.
+	srv.Inject(imaptest.Failure{Command: "NOOP", Response: "OK NOOP completed", Delay: time.Second})
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := noop(ctx, client); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if !errors.Is(client.Err(), ErrConnectionBroken) {
+		t.Errorf("Err() = %v", client.Err())
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	srv := newServer(t)
+	srv.SetCredentials(imaptest.DefaultUsername, "other")
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+	sqlDB, err := db.InitSqliteDB(ctx, dbPath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	t.Setenv("IMAP_EMAIL", imaptest.DefaultUsername)
+	t.Setenv("IMAP_PASSWORD", imaptest.DefaultPassword)
+	config := &ConnConfig{Security: SecurityPlain, AllowInsecureAuth: true}
+	_, err = NewMailClient(ctx, conn, config, db.NewRepository(sqlDB), nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Command != "LOGIN" || !HasCode(err, CodeAuthenticationFailed) {
+		t.Fatalf("err = %v, want LOGIN AUTHENTICATIONFAILED", err)
+	}
+}
@@ -0,0 +1,143 @@
+package mails
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/milkymilky0116/jellyfish/internal/db"
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+const (
+	FlagSeen     = `\Seen`
+	FlagAnswered = `\Answered`
+	FlagFlagged  = `\Flagged`
+	FlagDeleted  = `\Deleted`
+	FlagDraft    = `\Draft`
+)
+
+// ParseFlags splits the space separated system flags and keywords stored
+// with an email.
+func ParseFlags(flags string) []string {
+	return strings.Fields(flags)
+}
+
+func HasFlag(email repository.Email, flag string) bool {
+	return slices.ContainsFunc(ParseFlags(email.Flags), func(f string) bool {
+		return strings.EqualFold(f, flag)
+	})
+}
+
+func formatFlags(field Field) string {
+	flags := make([]string, 0, len(field.List))
+	for _, flag := range field.List {
+		flags = append(flags, flag.Value)
+	}
+	return strings.Join(flags, " ")
+}
+
+// StoreFlags adds or removes flags on one message with UID STORE and saves
+// the flags and modseq the server answers with.
+func (m *MailClient) StoreFlags(ctx context.Context, mailbox string, email repository.Email, add bool, flags ...string) (repository.Email, error) {
+	var updated []repository.Email
+	err := m.Do(ctx, func() error {
+		if m.CurrentMailBox != mailbox {
+			if err := m.SelectMailBox(ctx, mailbox); err != nil {
+				return err
+			}
+		}
+		operation := "-FLAGS"
+		if add {
+			operation = "+FLAGS"
+		}
+		code, err := m.SendMessage(ctx, "UID STORE", fmt.Sprintf("%d %s (%s)", email.Uid, operation, strings.Join(flags, " ")))
+		if err != nil {
+			return err
+		}
+		content, _, err := m.ParseIMAPContent(ctx, code)
+		if err != nil {
+			return err
+		}
+		updated, err = findEmailContent(content)
+		return err
+	})
+	if err != nil {
+		return email, err
+	}
+	if index := slices.IndexFunc(updated, func(mail repository.Email) bool { return mail.Uid == email.Uid }); index >= 0 {
+		email.Flags = updated[index].Flags
+		email.Modseq = max(updated[index].Modseq, email.Modseq)
+	} else {
+		email.Flags = applyFlags(email.Flags, add, flags)
+	}
+	cached, err := m.CacheRepository.GetCategory(ctx, mailbox)
+	if err != nil {
+		return email, err
+	}
+	updateFlagsParam := repository.UpdateEmailFlagsParams{
+		Flags:      email.Flags,
+		Modseq:     email.Modseq,
+		Uid:        email.Uid,
+		CategoryID: cached.ID,
+	}
+	err = m.CacheRepository.UpdateEmailFlags(ctx, updateFlagsParam)
+	if err != nil {
+		return email, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if category, ok := m.Emails[mailbox]; ok {
+		for i := range category.Mails {
+			if category.Mails[i].ID == email.ID {
+				category.Mails[i] = email
+			}
+		}
+	}
+	return email, nil
+}
+
+func applyFlags(current string, add bool, flags []string) string {
+	result := ParseFlags(current)
+	for _, flag := range flags {
+		index := slices.IndexFunc(result, func(f string) bool { return strings.EqualFold(f, flag) })
+		if add && index < 0 {
+			result = append(result, flag)
+		} else if !add && index >= 0 {
+			result = slices.Delete(result, index, index+1)
+		}
+	}
+	return strings.Join(result, " ")
+}
+
+// fetchFlags fetches the flags of cached messages on servers without
+// CONDSTORE, where changed messages cannot be asked for directly.
+func (m *MailClient) fetchFlags(ctx context.Context, lastUID uint32) ([]repository.Email, error) {
+	if lastUID == 0 {
+		return nil, nil
+	}
+	code, err := m.SendMessage(ctx, "UID FETCH", fmt.Sprintf("1:%d (UID FLAGS)", lastUID))
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := m.ParseIMAPContent(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	return findEmailContent(content)
+}
+
+func (m *MailClient) saveFlags(ctx context.Context, repo db.IRepository, categoryID int64, mails []repository.Email) error {
+	for _, mail := range mails {
+		updateFlagsParam := repository.UpdateEmailFlagsParams{
+			Flags:      mail.Flags,
+			Uid:        mail.Uid,
+			CategoryID: categoryID,
+		}
+		if err := repo.UpdateEmailFlags(ctx, updateFlagsParam); err != nil {
+			return err
+		}
+	}
+	return nil
+}
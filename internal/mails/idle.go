@@ -0,0 +1,300 @@
+package mails
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Servers may drop an IDLE after 30 minutes (RFC 2177), so the command is
+// re-issued well before that.
+const idleRestartInterval = 25 * time.Minute
+
+// Servers without IDLE are polled with NOOP instead.
+const pollInterval = time.Minute
+
+type commandRequest struct {
+	fn   func() error
+	done chan error
+}
+
+// Idle watches the currently selected mailbox and emits an IdleEvent with the
+// refreshed mail list whenever the server reports new, expunged or changed
+// messages. When the connection drops and Redial is set, Idle reconnects,
+// backing off between attempts, and emits Reconnecting events until it is
+// back. The channel is closed once ctx is cancelled or a command fails for
+// another reason. Servers that do not advertise IDLE are polled with NOOP.
+//
+// While Idle runs it owns the connection; other commands have to go through
+// Do. Watch switches it to another mailbox.
+func (m *MailClient) Idle(ctx context.Context) <-chan IdleEvent {
+	events := make(chan IdleEvent)
+	requests := make(chan commandRequest)
+	stopped := make(chan struct{})
+	m.connMu.Lock()
+	m.idleMu.Lock()
+	m.requests, m.idleStopped = requests, stopped
+	m.idleMu.Unlock()
+	go func() {
+		defer close(events)
+		defer func() {
+			m.idleMu.Lock()
+			m.requests, m.idleStopped = nil, nil
+			m.idleMu.Unlock()
+			close(stopped)
+			m.connMu.Unlock()
+		}()
+		m.watched = m.CurrentMailBox
+		for {
+			mailbox := m.watched
+			var changed, reconnected bool
+			var pending []commandRequest
+			var err error
+			if m.Err() != nil && m.Redial != nil {
+				err = m.reconnectLoop(ctx, events, requests, mailbox)
+				reconnected = err == nil
+			} else if m.Capabilities.Has(CapIdle) {
+				changed, pending, err = m.idleOnce(ctx, requests)
+			} else {
+				changed, pending, err = m.pollOnce(ctx, requests)
+			}
+			for _, req := range pending {
+				if err != nil {
+					req.done <- err
+				} else {
+					req.done <- req.fn()
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			// A command run in between may have called Watch.
+			mailbox = m.watched
+			if err == nil && m.CurrentMailBox != mailbox {
+				err = m.SelectMailBox(ctx, mailbox)
+				// The watched mailbox may have been deleted or renamed
+				// elsewhere, so INBOX is watched instead.
+				var statusErr *StatusError
+				if errors.As(err, &statusErr) && statusErr.Status == "NO" && mailbox != "INBOX" {
+					m.watched, mailbox = "INBOX", "INBOX"
+					err = m.SelectMailBox(ctx, mailbox)
+					changed = true
+				}
+			}
+			if err == nil && !changed && !reconnected {
+				continue
+			}
+			if err == nil && changed {
+				err = m.SyncMailBox(ctx, mailbox)
+			}
+			// A dropped connection is restored on the next round.
+			if err != nil && m.Err() != nil && m.Redial != nil && !HasCode(err, CodeAuthenticationFailed) {
+				continue
+			}
+			event := IdleEvent{Mailbox: mailbox, Err: err}
+			if err == nil {
+				event.Mails = m.Mails(mailbox)
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// Watch syncs mailbox and makes Idle watch it from then on.
+func (m *MailClient) Watch(ctx context.Context, mailbox string) error {
+	return m.Do(ctx, func() error {
+		if err := m.SyncMailBox(ctx, mailbox); err != nil {
+			return err
+		}
+		m.watched = mailbox
+		return nil
+	})
+}
+
+// Do runs fn with exclusive use of the connection. When Idle is running the
+// IDLE command is ended first and fn runs on the idle goroutine. Do gives up
+// waiting for the idle goroutine once ctx is done.
+func (m *MailClient) Do(ctx context.Context, fn func() error) error {
+	m.idleMu.Lock()
+	requests, stopped := m.requests, m.idleStopped
+	m.idleMu.Unlock()
+	if requests != nil {
+		req := commandRequest{fn: fn, done: make(chan error, 1)}
+		select {
+		case requests <- req:
+			return <-req.done
+		case <-stopped:
+		case <-ctx.Done():
+			return m.contextError(ctx, ctx.Err())
+		}
+	}
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	return fn()
+}
+
+// idleOnce runs a single IDLE command and returns after DONE has been
+// acknowledged, reporting whether the mailbox changed in the meantime and any
+// commands that interrupted it.
+func (m *MailClient) idleOnce(ctx context.Context, requests <-chan commandRequest) (bool, []commandRequest, error) {
+	code, err := m.SendMessage(ctx, "IDLE", "")
+	if err != nil {
+		return false, nil, err
+	}
+	// Once IDLE is sent, cancellation ends it with DONE instead of cutting the
+	// connection, so the exchange itself runs on an uncancelled context. The
+	// server may stay silent for the whole IDLE, so reads are only bounded by
+	// m.Timeout until it confirms and again once DONE has been sent.
+	done := ctx.Done()
+	ctx = context.WithoutCancel(ctx)
+	changed := false
+	for {
+		resp, err := m.readResponse(ctx)
+		if err != nil {
+			return false, nil, err
+		}
+		if resp.Tag == "+" {
+			break
+		}
+		if resp.Tag == code {
+			return false, nil, m.statusError(resp)
+		}
+		changed = changed || isMailboxUpdate(resp)
+	}
+
+	conn, bounded := m.Conn.(deadlineConn)
+	if bounded {
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			return false, nil, m.ioError(ctx, err)
+		}
+	}
+	responses := make(chan *Response)
+	errs := make(chan error, 1)
+	quit, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			resp, err := m.readRaw()
+			if err != nil {
+				errs <- m.ioError(ctx, err)
+				return
+			}
+			select {
+			case responses <- resp:
+			case <-quit:
+				return
+			}
+			if resp.Tag == code {
+				return
+			}
+		}
+	}()
+	// The reader has to be gone before anyone else touches m.Reader. It stops
+	// by itself after the tagged response or a read error; only a failed DONE
+	// leaves it reading, on a connection that is broken by then, so that read
+	// is cut short.
+	finished := false
+	defer func() {
+		close(quit)
+		if !finished {
+			if bounded {
+				conn.SetReadDeadline(time.Now())
+			} else {
+				m.Close()
+			}
+		}
+		<-exited
+	}()
+
+	timer := time.NewTimer(idleRestartInterval)
+	defer timer.Stop()
+	cancelled, expired := done, timer.C
+	stop := func() error {
+		if cancelled == nil {
+			return nil
+		}
+		cancelled, expired = nil, nil
+		if err := m.writeLine(ctx, "DONE", false); err != nil {
+			return err
+		}
+		if bounded && m.Timeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(m.Timeout)); err != nil {
+				return m.ioError(ctx, err)
+			}
+		}
+		return nil
+	}
+	pending := []commandRequest{}
+	for {
+		select {
+		case <-cancelled:
+			if err := stop(); err != nil {
+				return false, pending, err
+			}
+		case <-expired:
+			if err := stop(); err != nil {
+				return false, pending, err
+			}
+		case req := <-requests:
+			pending = append(pending, req)
+			if err := stop(); err != nil {
+				return false, pending, err
+			}
+		case err := <-errs:
+			finished = true
+			return false, pending, err
+		case resp := <-responses:
+			if resp.Tag == code {
+				finished = true
+				if resp.Name != "OK" {
+					return false, pending, m.statusError(resp)
+				}
+				return changed, pending, nil
+			}
+			if isMailboxUpdate(resp) {
+				changed = true
+				if err := stop(); err != nil {
+					return false, pending, err
+				}
+			}
+		}
+	}
+}
+
+func (m *MailClient) pollOnce(ctx context.Context, requests <-chan commandRequest) (bool, []commandRequest, error) {
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false, nil, nil
+	case req := <-requests:
+		return false, []commandRequest{req}, nil
+	case <-timer.C:
+	}
+	code, err := m.SendMessage(ctx, "NOOP", "")
+	if err != nil {
+		return false, nil, err
+	}
+	content, _, err := m.ParseIMAPContent(ctx, code)
+	if err != nil {
+		return false, nil, err
+	}
+	return slices.ContainsFunc(content, isMailboxUpdate), nil, nil
+}
+
+func isMailboxUpdate(resp *Response) bool {
+	switch resp.Name {
+	case "EXISTS", "EXPUNGE", "FETCH", "VANISHED":
+		return true
+	}
+	return false
+}
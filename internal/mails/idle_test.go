@@ -0,0 +1,201 @@
+package mails
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIdleEvent(t *testing.T) {
+	srv := newServer(t)
+	appendMail(t, srv, "INBOX", "one")
+	client := connect(t, srv, dbPath(t))
+	if err := client.SelectMailBox(context.Background(), "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	events := startIdle(t, srv, client)
+
+	appendMail(t, srv, "INBOX", "two")
+	event := nextEvent(t, events)
+	if event.Err != nil || event.State != Connected || event.Mailbox != "INBOX" {
+		t.Fatalf("event = %+v", event)
+	}
+	if got := subjects(event.Mails); !slices.Equal(got, []string{"one", "two"}) {
+		t.Errorf("subjects = %v", got)
+	}
+
+	srv.SetFlags("INBOX", 1, `\Seen`)
+	event = nextEvent(t, events)
+	if one := findMail(t, event.Mails, "one"); one.Flags != `\Seen` {
+		t.Errorf("flags of one = %q", one.Flags)
+	}
+}
+
+func TestIdleDo(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	// Idling longer than Timeout must not count as a hung server.
+	client.Timeout = 200 * time.Millisecond
+	events := startIdle(t, srv, client)
+	time.Sleep(2 * client.Timeout)
+
+	n := len(srv.Commands())
+	if err := client.Do(ctx, func() error { return client.Capability(ctx) }); err != nil {
+		t.Fatal(err)
+	}
+	if got := sentSince(srv, n); len(got) == 0 || got[0] != "CAPABILITY" {
+		t.Errorf("commands = %v, want CAPABILITY", got)
+	}
+	waitCommand(t, srv, "IDLE")
+	appendMail(t, srv, "INBOX", "after do")
+	if event := nextEvent(t, events); event.Err != nil || len(event.Mails) != 1 {
+		t.Errorf("event = %+v", event)
+	}
+	if err := client.Err(); err != nil {
+		t.Errorf("connection broken: %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	events := startIdle(t, srv, client)
+
+	if err := client.Watch(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+	waitCommand(t, srv, "IDLE")
+	appendMail(t, srv, "Work", "work mail")
+	event := nextEvent(t, events)
+	if event.Err != nil || event.Mailbox != "Work" {
+		t.Fatalf("event = %+v", event)
+	}
+	if got := subjects(event.Mails); !slices.Equal(got, []string{"work mail"}) {
+		t.Errorf("subjects = %v", got)
+	}
+	if client.CurrentMailBox != "Work" {
+		t.Errorf("CurrentMailBox = %q", client.CurrentMailBox)
+	}
+}
+
+func TestIdleStopsOnCancel(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	idleCtx, cancel := context.WithCancel(ctx)
+	events := client.Idle(idleCtx)
+	waitCommand(t, srv, "IDLE")
+	cancel()
+	for range events {
+	}
+	if err := client.Err(); err != nil {
+		t.Fatalf("connection broken: %v", err)
+	}
+	// IDLE was ended, so the connection is usable again without Do.
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchRenamed(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	events := startIdle(t, srv, client)
+	if err := client.Watch(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RenameMailBox(ctx, "Work", "Projects"); err != nil {
+		t.Fatal(err)
+	}
+	waitCommand(t, srv, "IDLE")
+	appendMail(t, srv, "Projects", "after rename")
+	event := nextEvent(t, events)
+	if event.Err != nil || event.Mailbox != "Projects" {
+		t.Fatalf("event = %+v, want one for Projects", event)
+	}
+	if got := subjects(event.Mails); !slices.Equal(got, []string{"after rename"}) {
+		t.Errorf("subjects = %v", got)
+	}
+}
+
+func TestWatchDeleted(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	events := startIdle(t, srv, client)
+	if err := client.Watch(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.DeleteMailBox(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+	waitCommand(t, srv, "IDLE")
+	appendMail(t, srv, "INBOX", "after delete")
+	event := nextEvent(t, events)
+	if event.Err != nil || event.Mailbox != "INBOX" {
+		t.Fatalf("event = %+v, want one for INBOX", event)
+	}
+	if got := subjects(event.Mails); !slices.Equal(got, []string{"after delete"}) {
+		t.Errorf("subjects = %v", got)
+	}
+}
+
+func TestWatchGoneElsewhere(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	appendMail(t, srv, "INBOX", "one")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	events := startIdle(t, srv, client)
+	if err := client.Watch(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+	other := connect(t, srv, dbPath(t))
+	if err := other.DeleteMailBox(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Idle selects Work again after the command and gets a NO.
+	if err := client.Do(ctx, func() error { return client.SelectMailBox(ctx, "INBOX") }); err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Err != nil || event.Mailbox != "INBOX" {
+		t.Fatalf("event = %+v, want a fallback to INBOX", event)
+	}
+	if got := subjects(event.Mails); !slices.Equal(got, []string{"one"}) {
+		t.Errorf("subjects = %v", got)
+	}
+	waitCommand(t, srv, "IDLE")
+	appendMail(t, srv, "INBOX", "two")
+	if event := nextEvent(t, events); event.Err != nil || len(event.Mails) != 2 {
+		t.Errorf("event = %+v", event)
+	}
+}
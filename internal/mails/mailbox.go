@@ -0,0 +1,373 @@
+package mails
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/milkymilky0116/jellyfish/internal/db"
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+// SPECIAL-USE roles from RFC 6154.
+const (
+	RoleAll     = `\All`
+	RoleArchive = `\Archive`
+	RoleDrafts  = `\Drafts`
+	RoleFlagged = `\Flagged`
+	RoleJunk    = `\Junk`
+	RoleSent    = `\Sent`
+	RoleTrash   = `\Trash`
+)
+
+// roleOrder is the order special mailboxes are listed in, after INBOX and
+// before every other mailbox.
+var roleOrder = []string{RoleDrafts, RoleSent, RoleArchive, RoleAll, RoleFlagged, RoleJunk, RoleTrash}
+
+// wellKnownRoles guesses roles from common folder names for servers that do
+// not support SPECIAL-USE.
+var wellKnownRoles = map[string]string{
+	"drafts":           RoleDrafts,
+	"sent":             RoleSent,
+	"sent items":       RoleSent,
+	"sent messages":    RoleSent,
+	"sent mail":        RoleSent,
+	"archive":          RoleArchive,
+	"archives":         RoleArchive,
+	"junk":             RoleJunk,
+	"spam":             RoleJunk,
+	"trash":            RoleTrash,
+	"deleted items":    RoleTrash,
+	"deleted messages": RoleTrash,
+}
+
+func mailboxRole(info MailBoxInfo) string {
+	for _, attr := range info.Attributes {
+		for _, role := range roleOrder {
+			if strings.EqualFold(attr, role) {
+				return role
+			}
+		}
+	}
+	name, err := DecodeModifiedUTF7(info.Name)
+	if err != nil {
+		return ""
+	}
+	if info.Delimiter != "" {
+		name = name[strings.LastIndex(name, info.Delimiter)+1:]
+	}
+	return wellKnownRoles[strings.ToLower(name)]
+}
+
+// MailBoxes returns the known mailboxes without their mails, with Unseen
+// counted from the cache or taken from STATUS when nothing is cached: INBOX
+// first, then the special-use mailboxes, then the rest by name.
+func (m *MailClient) MailBoxes() []Category {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mailboxes := []Category{}
+	for _, category := range m.Emails {
+		mailbox := *category
+		mailbox.Mails = nil
+		if len(category.Mails) == 0 && category.Status != nil {
+			mailbox.Unseen = int(category.Status.Unseen)
+		}
+		for _, mail := range category.Mails {
+			if !HasFlag(mail, FlagSeen) {
+				mailbox.Unseen++
+			}
+		}
+		mailboxes = append(mailboxes, mailbox)
+	}
+	slices.SortFunc(mailboxes, func(a, b Category) int {
+		if rank := mailboxRank(a) - mailboxRank(b); rank != 0 {
+			return rank
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return mailboxes
+}
+
+func mailboxRank(category Category) int {
+	if strings.EqualFold(category.Name, "INBOX") {
+		return 0
+	}
+	if index := slices.Index(roleOrder, category.Role); index >= 0 {
+		return index + 1
+	}
+	return len(roleOrder) + 1
+}
+
+// MailBoxByRole returns the mailbox with a special-use role such as RoleTrash.
+func (m *MailClient) MailBoxByRole(role string) (string, bool) {
+	for _, mailbox := range m.MailBoxes() {
+		if mailbox.Role == role {
+			return mailbox.Name, true
+		}
+	}
+	return "", false
+}
+
+func (m *MailClient) saveMailBoxAttributes(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for key, category := range m.Emails {
+		updateAttributesParam := repository.UpdateCategoryAttributesParams{
+			Attributes: strings.Join(category.Attributes, " "),
+			Role:       category.Role,
+			Delimiter:  category.Delimiter,
+			Key:        key,
+		}
+		if err := m.CacheRepository.UpdateCategoryAttributes(ctx, updateAttributesParam); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateMailBox creates a mailbox from its display name and caches it as an
+// empty category.
+func (m *MailClient) CreateMailBox(ctx context.Context, name string) error {
+	key := EncodeModifiedUTF7(name)
+	err := m.Do(ctx, func() error {
+		code, err := m.SendMessage(ctx, "CREATE", quoteString(key))
+		if err != nil {
+			return err
+		}
+		return m.ReadMessage(ctx, code)
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	createCategoryParam := repository.CreateCategoryParams{
+		Name: name,
+		Key:  key,
+	}
+	_, err = m.CacheRepository.CreateCategory(ctx, createCategoryParam)
+	if err != nil {
+		return err
+	}
+	info := MailBoxInfo{Name: key, Delimiter: m.delimiter()}
+	m.Emails[key] = &Category{Name: key, Delimiter: info.Delimiter, Role: mailboxRole(info), Mails: []repository.Email{}}
+	return nil
+}
+
+// RenameMailBox renames a mailbox to a new display name. The server renames
+// the mailboxes below it as well, so their cached categories follow.
+func (m *MailClient) RenameMailBox(ctx context.Context, key, newName string) error {
+	if strings.EqualFold(key, "INBOX") {
+		return errors.New("INBOX cannot be renamed")
+	}
+	newKey := EncodeModifiedUTF7(newName)
+	m.mu.RLock()
+	delimiter := m.delimiter()
+	m.mu.RUnlock()
+	err := m.Do(ctx, func() error {
+		code, err := m.SendMessage(ctx, "RENAME", quoteString(key)+" "+quoteString(newKey))
+		if err != nil {
+			return err
+		}
+		err = m.ReadMessage(ctx, code)
+		if err != nil {
+			return err
+		}
+		if renamedKey, ok := renamedMailBox(m.CurrentMailBox, key, newKey, delimiter); ok {
+			m.CurrentMailBox = renamedKey
+		}
+		if renamedKey, ok := renamedMailBox(m.watched, key, newKey, delimiter); ok {
+			m.watched = renamedKey
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err = m.CacheRepository.WithTx(ctx, func(repo db.IRepository) error {
+		cached, err := repo.ListCategories(ctx)
+		if err != nil {
+			return err
+		}
+		for _, category := range cached {
+			renamedKey, ok := renamedMailBox(category.Key, key, newKey, delimiter)
+			if !ok {
+				continue
+			}
+			decodedName, err := DecodeModifiedUTF7(renamedKey)
+			if err != nil {
+				return err
+			}
+			renameCategoryParam := repository.RenameCategoryParams{
+				Name: decodedName,
+				Key:  renamedKey,
+				ID:   category.ID,
+			}
+			if err := repo.RenameCategory(ctx, renameCategoryParam); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	renamed := map[string]*Category{}
+	for oldKey, category := range m.Emails {
+		if renamedKey, ok := renamedMailBox(oldKey, key, newKey, delimiter); ok {
+			delete(m.Emails, oldKey)
+			category.Name = renamedKey
+			renamed[renamedKey] = category
+		}
+	}
+	for renamedKey, category := range renamed {
+		m.Emails[renamedKey] = category
+	}
+	return nil
+}
+
+// delimiter returns the hierarchy delimiter of the personal namespace, which
+// all mailboxes share. The caller holds m.mu.
+func (m *MailClient) delimiter() string {
+	for _, category := range m.Emails {
+		if category.Delimiter != "" {
+			return category.Delimiter
+		}
+	}
+	return ""
+}
+
+func renamedMailBox(mailbox, oldKey, newKey, delimiter string) (string, bool) {
+	if mailbox == oldKey {
+		return newKey, true
+	}
+	if delimiter != "" && strings.HasPrefix(mailbox, oldKey+delimiter) {
+		return newKey + strings.TrimPrefix(mailbox, oldKey), true
+	}
+	return "", false
+}
+
+// DeleteMailBox deletes a mailbox and drops its cached mails. Mailboxes below
+// it are left alone, as the server does.
+func (m *MailClient) DeleteMailBox(ctx context.Context, key string) error {
+	if strings.EqualFold(key, "INBOX") {
+		return errors.New("INBOX cannot be deleted")
+	}
+	err := m.Do(ctx, func() error {
+		if m.CurrentMailBox == key {
+			if err := m.SelectMailBox(ctx, "INBOX"); err != nil {
+				return err
+			}
+		}
+		code, err := m.SendMessage(ctx, "DELETE", quoteString(key))
+		if err != nil {
+			return err
+		}
+		err = m.ReadMessage(ctx, code)
+		if err != nil {
+			return err
+		}
+		if m.watched == key {
+			m.watched = "INBOX"
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Emails, key)
+	cached, err := m.CacheRepository.GetCategory(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.CacheRepository.WithTx(ctx, func(repo db.IRepository) error {
+		if err := repo.DeleteCategoryEmails(ctx, cached.ID); err != nil {
+			return err
+		}
+		return repo.DeleteCategory(ctx, cached.ID)
+	})
+}
+
+func (m *MailClient) Subscribe(ctx context.Context, key string, subscribe bool) error {
+	command := "UNSUBSCRIBE"
+	if subscribe {
+		command = "SUBSCRIBE"
+	}
+	err := m.Do(ctx, func() error {
+		code, err := m.SendMessage(ctx, command, quoteString(key))
+		if err != nil {
+			return err
+		}
+		return m.ReadMessage(ctx, code)
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if category, ok := m.Emails[key]; ok {
+		category.Subscribed = subscribe
+	}
+	setSubscribedParam := repository.SetCategorySubscribedParams{
+		Subscribed: subscribe,
+		Key:        key,
+	}
+	return m.CacheRepository.SetCategorySubscribed(ctx, setSubscribedParam)
+}
+
+// ListSubscribed returns the subscribed mailboxes, using LIST (SUBSCRIBED)
+// where the server supports it and LSUB otherwise.
+func (m *MailClient) ListSubscribed(ctx context.Context) ([]string, error) {
+	command, args := "LSUB", `"" "*"`
+	if m.Capabilities.Has(CapListExtended) || m.Capabilities.Has(CapIMAP4rev2) {
+		command, args = "LIST", `(SUBSCRIBED) "" "*"`
+	}
+	code, err := m.SendMessage(ctx, command, args)
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := m.ParseIMAPContent(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	mailboxes, err := findEmailBox(content)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, mailbox := range mailboxes {
+		names = append(names, mailbox.Name)
+	}
+	return names, nil
+}
+
+// SyncSubscriptions marks the known mailboxes as subscribed or not, both in
+// memory and in the category table.
+func (m *MailClient) SyncSubscriptions(ctx context.Context) error {
+	subscribed, err := m.ListSubscribed(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, category := range m.Emails {
+		category.Subscribed = slices.Contains(subscribed, key)
+		setSubscribedParam := repository.SetCategorySubscribedParams{
+			Subscribed: category.Subscribed,
+			Key:        key,
+		}
+		if err := m.CacheRepository.SetCategorySubscribed(ctx, setSubscribedParam); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package mails
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/milkymilky0116/jellyfish/internal/imaptest"
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+// cachedCategories returns the cached categories by key.
+func cachedCategories(t *testing.T, client *MailClient) map[string]repository.Category {
+	t.Helper()
+	categories, err := client.CacheRepository.ListCategories(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := map[string]repository.Category{}
+	for _, category := range categories {
+		byKey[category.Key] = category
+	}
+	return byKey
+}
+
+func mailboxNames(client *MailClient) []string {
+	names := []string{}
+	for _, mailbox := range client.MailBoxes() {
+		names = append(names, mailbox.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestCreateMailBox(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+
+	if err := client.CreateMailBox(ctx, "Work/台北"); err != nil {
+		t.Fatal(err)
+	}
+	key := "Work/&U,BTFw-"
+	if _, ok := srv.MailBox(key); !ok {
+		t.Fatalf("server mailboxes = %v, want %s", srv.MailBoxes(), key)
+	}
+	if got, want := mailboxNames(client), []string{"INBOX", "Work", key}; !slices.Equal(got, want) {
+		t.Errorf("mailboxes = %v, want %v", got, want)
+	}
+	if cached, ok := cachedCategories(t, client)[key]; !ok || cached.Name != "Work/台北" {
+		t.Errorf("cached category = %+v, %v", cached, ok)
+	}
+	if err := client.CreateMailBox(ctx, "Work"); err == nil {
+		t.Error("creating an existing mailbox succeeded")
+	}
+}
+
+func TestRenameMailBox(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	srv.CreateMailBox("Work/Reports")
+	srv.CreateMailBox("Workshop")
+	appendMail(t, srv, "Work/Reports", "q3")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "Work/Reports"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RenameMailBox(ctx, "Work", "Projects"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.MailBoxes(), []string{"INBOX", "Projects", "Projects/Reports", "Workshop"}; !slices.Equal(got, want) {
+		t.Errorf("server mailboxes = %v, want %v", got, want)
+	}
+	if got, want := mailboxNames(client), []string{"INBOX", "Projects", "Projects/Reports", "Workshop"}; !slices.Equal(got, want) {
+		t.Errorf("mailboxes = %v, want %v", got, want)
+	}
+	if client.CurrentMailBox != "Projects/Reports" {
+		t.Errorf("CurrentMailBox = %q", client.CurrentMailBox)
+	}
+	if got := subjects(client.Mails("Projects/Reports")); !slices.Equal(got, []string{"q3"}) {
+		t.Errorf("Projects/Reports subjects = %v", got)
+	}
+	cached := cachedCategories(t, client)
+	for _, key := range []string{"Projects", "Projects/Reports", "Workshop"} {
+		if cached[key].Name != key {
+			t.Errorf("cached %s = %+v", key, cached[key])
+		}
+	}
+	if _, ok := cached["Work"]; ok {
+		t.Error("Work is still cached")
+	}
+
+	if err := client.RenameMailBox(ctx, "Workshop", "台北"); err != nil {
+		t.Fatal(err)
+	}
+	if cached := cachedCategories(t, client)["&U,BTFw-"]; cached.Name != "台北" {
+		t.Errorf("cached category = %+v", cached)
+	}
+	if err := client.RenameMailBox(ctx, "INBOX", "Old"); err == nil {
+		t.Error("renaming INBOX succeeded")
+	}
+}
+
+func TestDeleteMailBox(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	srv.CreateMailBox("Work/Reports")
+	appendMail(t, srv, "Work", "gone")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.DeleteMailBox(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.MailBoxes(), []string{"INBOX", "Work/Reports"}; !slices.Equal(got, want) {
+		t.Errorf("server mailboxes = %v, want %v", got, want)
+	}
+	if got, want := mailboxNames(client), []string{"INBOX", "Work/Reports"}; !slices.Equal(got, want) {
+		t.Errorf("mailboxes = %v, want %v", got, want)
+	}
+	if client.CurrentMailBox != "INBOX" {
+		t.Errorf("CurrentMailBox = %q, want INBOX", client.CurrentMailBox)
+	}
+	if _, ok := cachedCategories(t, client)["Work"]; ok {
+		t.Error("Work is still cached")
+	}
+	if err := client.DeleteMailBox(ctx, "INBOX"); err == nil {
+		t.Error("deleting INBOX succeeded")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string
+		lsub         bool
+	}{
+		{"list subscribed", imaptest.DefaultCapabilities, false},
+		{"lsub", []string{"IMAP4rev1", "AUTH=PLAIN"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.SetCapabilities(tt.capabilities...)
+			srv.CreateMailBox("Work")
+			srv.CreateMailBox("News")
+			client := connect(t, srv, dbPath(t))
+			ctx := context.Background()
+
+			if err := client.Subscribe(ctx, "Work", true); err != nil {
+				t.Fatal(err)
+			}
+			if mailbox, _ := srv.MailBox("Work"); !mailbox.Subscribed {
+				t.Error("server did not subscribe Work")
+			}
+			if !cachedCategories(t, client)["Work"].Subscribed {
+				t.Error("cache did not subscribe Work")
+			}
+
+			// Subscriptions changed by another client are picked up.
+			other := connect(t, srv, dbPath(t))
+			if err := other.Subscribe(ctx, "Work", false); err != nil {
+				t.Fatal(err)
+			}
+			if err := other.Subscribe(ctx, "News", true); err != nil {
+				t.Fatal(err)
+			}
+			n := len(srv.Commands())
+			if err := client.Do(ctx, func() error { return client.SyncSubscriptions(ctx) }); err != nil {
+				t.Fatal(err)
+			}
+			if got := sentSince(srv, n); hasCommand(got, "LSUB") != tt.lsub {
+				t.Errorf("commands = %v", got)
+			}
+			subscribed := []string{}
+			for _, mailbox := range client.MailBoxes() {
+				if mailbox.Subscribed {
+					subscribed = append(subscribed, mailbox.Name)
+				}
+			}
+			slices.Sort(subscribed)
+			if want := []string{"INBOX", "News"}; !slices.Equal(subscribed, want) {
+				t.Errorf("subscribed = %v, want %v", subscribed, want)
+			}
+			cached := cachedCategories(t, client)
+			if cached["Work"].Subscribed || !cached["News"].Subscribed {
+				t.Errorf("cached Work = %+v, News = %+v", cached["Work"], cached["News"])
+			}
+		})
+	}
+}
@@ -3,106 +3,422 @@ package mails
 import (
 	"bufio"
 	"context"
-	"crypto/tls"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
 
 	"github.com/milkymilky0116/jellyfish/internal/db"
 	"github.com/milkymilky0116/jellyfish/internal/repository"
 )
 
-func InitMailClient(url string, repo db.IRepository) (*MailClient, error) {
-	conn, err := tls.Dial("tcp", url, nil)
+const headerFetchItems = "UID FLAGS ENVELOPE INTERNALDATE RFC822.SIZE BODYSTRUCTURE"
+
+// InitMailClient connects to url with the settings from the environment.
+// When transcript is not nil the session is recorded to it, along with the
+// sessions of any later reconnection.
+func InitMailClient(ctx context.Context, url string, repo db.IRepository, tracer *Tracer, transcript io.Writer) (*MailClient, error) {
+	config, err := ConnConfigFromEnv(url)
+	if err != nil {
+		return nil, err
+	}
+	redial := func(ctx context.Context) (io.ReadWriter, error) {
+		conn, err := Dial(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("connected to IMAP server", "addr", conn.RemoteAddr())
+		if transcript != nil {
+			return NewRecorder(conn, transcript), nil
+		}
+		return conn, nil
+	}
+	conn, err := redial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewMailClient(ctx, conn, config, repo, tracer)
 	if err != nil {
 		return nil, err
 	}
+	client.Redial = redial
+	return client, nil
+}
 
-	fmt.Println("✅ Connect to IMAP Server", conn.RemoteAddr())
+// NewMailClient starts a session on an established connection: it reads the
+// greeting, upgrades with STARTTLS when configured, logs in and syncs every
+// mailbox. Any io.ReadWriter works, though STARTTLS needs a net.Conn.
+func NewMailClient(ctx context.Context, conn io.ReadWriter, config *ConnConfig, repo db.IRepository, tracer *Tracer) (*MailClient, error) {
 	mailsClient := InitMails(conn, repo)
-	err = mailsClient.Login()
+	mailsClient.Tracer = tracer
+	mailsClient.Server = config.Address
+	mailsClient.AllowInsecureAuth = config.AllowInsecureAuth
+	mailsClient.Timeout = config.Timeout
+	mailsClient.Config = config
+	err := mailsClient.startSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = mailsClient.ListMailBox(ctx)
 	if err != nil {
 		return nil, err
 	}
-	err = mailsClient.ListMailBox()
+	err = mailsClient.SyncAll(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for name, category := range mailsClient.Emails {
-		// 1. Iterate Each Category, select mailbox
-		err = mailsClient.SelectMailBox(name)
+	err = mailsClient.SyncSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = mailsClient.saveMailBoxAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mailsClient, nil
+}
+
+// startSession takes a fresh connection from the greeting to an
+// authenticated state, upgrading with STARTTLS when configured and enabling
+// QRESYNC when the server has it.
+func (m *MailClient) startSession(ctx context.Context) error {
+	err := m.ReadGreeting(ctx)
+	if err != nil {
+		return err
+	}
+	// A greeting without a CAPABILITY code leaves STARTTLS unknown until
+	// the server is asked.
+	if len(m.Capabilities) == 0 {
+		err = m.Capability(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if m.Config.Security == SecuritySTARTTLS {
+		err = m.StartTLS(ctx, m.Config.TLSConfig)
+		if err != nil {
+			return err
+		}
+	}
+	err = m.Login(ctx)
+	if err != nil {
+		return err
+	}
+	if m.Capabilities.Has(CapQResync) && m.Capabilities.Has(CapEnable) {
+		return m.Enable(ctx, "QRESYNC")
+	}
+	return nil
+}
+
+// SyncMailBox brings a mailbox and its cache up to date. The sync works on a
+// copy of the category, so readers such as Mails keep seeing the previous
+// mails without waiting on the network until the new ones are in place.
+func (m *MailClient) SyncMailBox(ctx context.Context, name string) error {
+	m.mu.RLock()
+	_, ok := m.Emails[name]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown mailbox %q", name)
+	}
+	err := m.SelectMailBox(ctx, name)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	live := m.Emails[name]
+	category := *live
+	m.mu.RUnlock()
+	var cached *repository.Category
+	existedCategory, err := m.CacheRepository.GetCategory(ctx, name)
+	if err == nil {
+		cached = &existedCategory
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	// Everything is fetched before the transaction starts, so the cache is
+	// not locked while waiting on the server.
+	changes, err := m.fetchChanges(ctx, &category, cached)
+	if err != nil {
+		return err
+	}
+	// The mails and the sync state of a mailbox are written in one
+	// transaction, so an interrupted sync never leaves a category that claims
+	// to be up to date with part of its mail missing.
+	err = m.CacheRepository.WithTx(ctx, func(repo db.IRepository) error {
+		if cached == nil {
+			return m.cacheCategory(ctx, repo, &category)
+		}
+		return m.syncCategory(ctx, repo, &category, *cached, changes)
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	*live = category
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MailClient) Mails(name string) []repository.Email {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	category, ok := m.Emails[name]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(category.Mails)
+}
+
+// fetchChanges fetches what changed in a selected mailbox since it was
+// cached: every mail for a new category or a new UIDVALIDITY, otherwise the
+// new and changed mails in category.Mails and the expunged UIDs in
+// category.Vanished.
+func (m *MailClient) fetchChanges(ctx context.Context, category *Category, cached *repository.Category) (*mailboxChanges, error) {
+	changes := &mailboxChanges{}
+	if cached == nil {
+		return changes, m.FetchMail(ctx, category)
+	}
+	if cached.Uidvalidity != int64(category.UIDValidity) {
+		slog.Debug("UIDVALIDITY changed, purging cached emails", "mailbox", category.Name)
+		changes.purge = true
+		return changes, m.FetchMail(ctx, category)
+	}
+	if !m.Enabled["QRESYNC"] {
+		expunged, err := m.findExpunged(ctx, cached.ID)
 		if err != nil {
 			return nil, err
 		}
-		existedCategory, err := mailsClient.CacheRepository.GetCategory(context.TODO(), name)
+		category.Vanished = append(category.Vanished, expunged...)
+	}
+	if !m.Capabilities.Has(CapCondStore) || category.HighestModSeq == 0 {
+		lastUID, err := m.lastCachedUID(ctx, cached.ID)
 		if err != nil {
-			// 2. if category does not exists on db, create new category, caching emails, save latest modseq
-			fmt.Println("Create new category, caching email..")
-			decodedName, err := DecodeModifiedUTF7(category.Name)
-			if err != nil {
-				return nil, err
-			}
-			createCategoryParam := repository.CreateCategoryParams{
-				Name:   decodedName,
-				Key:    category.Name,
-				Modseq: int64(category.TotalMails),
-			}
-			newCategory, err := mailsClient.CacheRepository.CreateCategory(context.TODO(), createCategoryParam)
-			if err != nil {
-				return nil, err
-			}
-			err = mailsClient.FetchMail(category)
-			if err != nil {
-				return nil, err
-			}
-			for _, mail := range category.Mails {
-				createEmailParam := repository.CreateEmailParams{
-					Seq:       mail.Seq,
-					Sender:    mail.Sender,
-					Subject:   mail.Subject,
-					EmailDate: mail.EmailDate,
-				}
-				newEmail, err := mailsClient.CacheRepository.CreateEmail(context.TODO(), createEmailParam)
-				if err != nil {
-					return nil, err
-				}
-				registerEmailCategoryParam := repository.RegisterEmailAndCategoryParams{
-					EmailID:    newEmail.ID,
-					CategoryID: newCategory.ID,
-				}
-				err = mailsClient.CacheRepository.RegisterEmailAndCategory(context.TODO(), registerEmailCategoryParam)
-				if err != nil {
-					return nil, err
-				}
-			}
-		} else {
-			fmt.Println("Category Already Cached")
-			// 3. if exists, search latest modseq
+			return nil, err
+		}
+		changes.flags, err = m.fetchFlags(ctx, lastUID)
+		if err != nil {
+			return nil, err
+		}
+		return changes, m.FetchNewMail(ctx, category, lastUID)
+	}
+	if category.HighestModSeq != cached.Modseq {
+		slog.Debug("fetching changed emails", "mailbox", category.Name, "modseq", cached.Modseq)
+		changes.modseq = true
+		return changes, m.FetchChangedMail(ctx, category, cached.Modseq)
+	}
+	category.Mails = nil
+	return changes, nil
+}
 
-			_, err = mailsClient.FindUpdatedEmail(int(existedCategory.Modseq))
-			if err != nil {
-				return nil, err
+func (m *MailClient) cacheCategory(ctx context.Context, repo db.IRepository, category *Category) error {
+	slog.Debug("caching new category", "mailbox", category.Name)
+	decodedName, err := DecodeModifiedUTF7(category.Name)
+	if err != nil {
+		return err
+	}
+	createCategoryParam := repository.CreateCategoryParams{
+		Name:        decodedName,
+		Key:         category.Name,
+		Modseq:      category.HighestModSeq,
+		Uidvalidity: int64(category.UIDValidity),
+	}
+	newCategory, err := repo.CreateCategory(ctx, createCategoryParam)
+	if err != nil {
+		return err
+	}
+	for _, mail := range category.Mails {
+		if err := m.saveEmail(ctx, repo, newCategory.ID, mail); err != nil {
+			return err
+		}
+	}
+	return m.loadMails(ctx, repo, newCategory.ID, category)
+}
+
+// loadMails replaces the fetched mails of a category with their cached rows,
+// which carry the database IDs and the display order.
+func (m *MailClient) loadMails(ctx context.Context, repo db.IRepository, categoryID int64, category *Category) error {
+	mails, err := repo.ListEmailsByCategory(ctx, categoryID)
+	if err != nil {
+		return err
+	}
+	category.Mails = mails
+	return nil
+}
+
+// syncCategory writes the changes fetched by fetchChanges to a cached
+// category.
+func (m *MailClient) syncCategory(ctx context.Context, repo db.IRepository, category *Category, cached repository.Category, changes *mailboxChanges) error {
+	if changes.purge {
+		purgeParam := repository.PurgeStaleEmailsParams{
+			Uidvalidity: int64(category.UIDValidity),
+			CategoryID:  cached.ID,
+		}
+		err := repo.PurgeStaleEmails(ctx, purgeParam)
+		if err != nil {
+			return err
+		}
+		for _, mail := range category.Mails {
+			if err := m.saveEmail(ctx, repo, cached.ID, mail); err != nil {
+				return err
 			}
-			// 4. if modseq is not equal to db's modseq, then search updated emails
-			// 5. update db
 		}
+		err = m.saveSyncState(ctx, repo, cached.ID, category)
+		if err != nil {
+			return err
+		}
+		return m.loadMails(ctx, repo, cached.ID, category)
 	}
-	return mailsClient, nil
+	for _, uid := range category.Vanished {
+		deleteEmailParam := repository.DeleteEmailByUIDParams{
+			Uid:        int64(uid),
+			CategoryID: cached.ID,
+		}
+		if err := repo.DeleteEmailByUID(ctx, deleteEmailParam); err != nil {
+			return err
+		}
+	}
+	category.Vanished = nil
+	err := m.saveFlags(ctx, repo, cached.ID, changes.flags)
+	if err != nil {
+		return err
+	}
+	for _, mail := range category.Mails {
+		if err := m.upsertEmail(ctx, repo, cached.ID, mail); err != nil {
+			return err
+		}
+	}
+	if changes.modseq {
+		err = m.saveSyncState(ctx, repo, cached.ID, category)
+		if err != nil {
+			return err
+		}
+	}
+	return m.loadMails(ctx, repo, cached.ID, category)
+}
+
+func (m *MailClient) findExpunged(ctx context.Context, categoryID int64) ([]uint32, error) {
+	cachedUIDs, err := m.CacheRepository.ListEmailUIDsByCategory(ctx, categoryID)
+	if err != nil || len(cachedUIDs) == 0 {
+		return nil, err
+	}
+	serverUIDs, err := m.SearchUIDs(ctx, "ALL")
+	if err != nil {
+		return nil, err
+	}
+	onServer := make(map[uint32]bool, len(serverUIDs))
+	for _, uid := range serverUIDs {
+		onServer[uid] = true
+	}
+	expunged := []uint32{}
+	for _, uid := range cachedUIDs {
+		if !onServer[uint32(uid)] {
+			expunged = append(expunged, uint32(uid))
+		}
+	}
+	return expunged, nil
 }
 
-func InitMails(conn *tls.Conn, repo db.IRepository) *MailClient {
+func (m *MailClient) lastCachedUID(ctx context.Context, categoryID int64) (uint32, error) {
+	cachedUIDs, err := m.CacheRepository.ListEmailUIDsByCategory(ctx, categoryID)
+	if err != nil || len(cachedUIDs) == 0 {
+		return 0, err
+	}
+	return uint32(cachedUIDs[len(cachedUIDs)-1]), nil
+}
+
+func (m *MailClient) saveSyncState(ctx context.Context, repo db.IRepository, categoryID int64, category *Category) error {
+	syncStateParam := repository.UpdateCategorySyncStateParams{
+		Modseq:      category.HighestModSeq,
+		Uidvalidity: int64(category.UIDValidity),
+		ID:          categoryID,
+	}
+	return repo.UpdateCategorySyncState(ctx, syncStateParam)
+}
+
+func (m *MailClient) saveEmail(ctx context.Context, repo db.IRepository, categoryID int64, mail repository.Email) error {
+	createEmailParam := repository.CreateEmailParams{
+		Uid:           mail.Uid,
+		Uidvalidity:   mail.Uidvalidity,
+		Sender:        mail.Sender,
+		Subject:       mail.Subject,
+		EmailDate:     mail.EmailDate,
+		BodyStructure: mail.BodyStructure,
+		SenderAddr:    mail.SenderAddr,
+		ReplyTo:       mail.ReplyTo,
+		ToAddrs:       mail.ToAddrs,
+		CcAddrs:       mail.CcAddrs,
+		BccAddrs:      mail.BccAddrs,
+		MessageID:     mail.MessageID,
+		InReplyTo:     mail.InReplyTo,
+		InternalDate:  mail.InternalDate,
+		Size:          mail.Size,
+		Flags:         mail.Flags,
+		Modseq:        mail.Modseq,
+	}
+	newEmail, err := repo.CreateEmail(ctx, createEmailParam)
+	if err != nil {
+		return err
+	}
+	registerEmailCategoryParam := repository.RegisterEmailAndCategoryParams{
+		EmailID:    newEmail.ID,
+		CategoryID: categoryID,
+	}
+	return repo.RegisterEmailAndCategory(ctx, registerEmailCategoryParam)
+}
+
+func (m *MailClient) upsertEmail(ctx context.Context, repo db.IRepository, categoryID int64, mail repository.Email) error {
+	getEmailParam := repository.GetEmailByUIDParams{
+		CategoryID:  categoryID,
+		Uidvalidity: mail.Uidvalidity,
+		Uid:         mail.Uid,
+	}
+	existing, err := repo.GetEmailByUID(ctx, getEmailParam)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m.saveEmail(ctx, repo, categoryID, mail)
+	}
+	if err != nil {
+		return err
+	}
+	updateEmailParam := repository.UpdateEmailParams{
+		Sender:        mail.Sender,
+		Subject:       mail.Subject,
+		EmailDate:     mail.EmailDate,
+		BodyStructure: mail.BodyStructure,
+		SenderAddr:    mail.SenderAddr,
+		ReplyTo:       mail.ReplyTo,
+		ToAddrs:       mail.ToAddrs,
+		CcAddrs:       mail.CcAddrs,
+		BccAddrs:      mail.BccAddrs,
+		MessageID:     mail.MessageID,
+		InReplyTo:     mail.InReplyTo,
+		InternalDate:  mail.InternalDate,
+		Size:          mail.Size,
+		Flags:         mail.Flags,
+		Modseq:        mail.Modseq,
+		ID:            existing.ID,
+	}
+	_, err = repo.UpdateEmail(ctx, updateEmailParam)
+	return err
+}
+
+func InitMails(conn io.ReadWriter, repo db.IRepository) *MailClient {
 	return &MailClient{
 		Writer:          bufio.NewWriter(conn),
 		Reader:          bufio.NewReader(conn),
 		Conn:            conn,
 		ClienEmail:      os.Getenv("IMAP_EMAIL"),
 		ClientPassword:  os.Getenv("IMAP_PASSWORD"),
+		AuthMechanism:   os.Getenv("IMAP_AUTH"),
+		TokenSource:     tokenSourceFromEnv(),
 		CacheRepository: repo,
 		Emails:          make(map[string]*Category),
+		Enabled:         make(map[string]bool),
+		alerts:          make(chan string, alertBuffer),
 	}
 }
 
@@ -111,197 +427,337 @@ func (m *MailClient) NextTag() string {
 	return fmt.Sprintf("a%03d", m.TagSeq)
 }
 
-func (m *MailClient) Login() error {
-	code, err := m.SendMessage("LOGIN", fmt.Sprintf("\"%s\" \"%s\"", m.ClienEmail, m.ClientPassword))
+func (m *MailClient) Login(ctx context.Context) error {
+	if !m.IsSecure() && !m.AllowInsecureAuth {
+		return ErrInsecureAuth
+	}
+	switch strings.ToUpper(m.AuthMechanism) {
+	case "", "LOGIN":
+		if m.Capabilities.Has(CapLoginDisabled) && m.AuthMechanism == "" {
+			return m.Authenticate(ctx, PlainAuth(m.ClienEmail, m.ClientPassword))
+		}
+	case "PLAIN":
+		return m.Authenticate(ctx, PlainAuth(m.ClienEmail, m.ClientPassword))
+	case "XOAUTH2", "OAUTHBEARER":
+		if m.TokenSource == nil {
+			return fmt.Errorf("%s needs IMAP_TOKEN_FILE or IMAP_TOKEN_COMMAND", m.AuthMechanism)
+		}
+		token, err := m.TokenSource.Token(ctx)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(m.AuthMechanism, "XOAUTH2") {
+			return m.Authenticate(ctx, XOAuth2(m.ClienEmail, token))
+		}
+		host, port, _ := net.SplitHostPort(m.Server)
+		return m.Authenticate(ctx, OAuthBearer(m.ClienEmail, token, host, port))
+	default:
+		return fmt.Errorf("unsupported authentication mechanism %q", m.AuthMechanism)
+	}
+	if m.Capabilities.Has(CapLoginDisabled) {
+		return errors.New("server does not allow LOGIN on this connection")
+	}
+	m.Capabilities = nil
+	code, err := m.SendMessage(ctx, "LOGIN", fmt.Sprintf("%s %s", quoteString(m.ClienEmail), quoteString(m.ClientPassword)))
 	if err != nil {
-		log.Printf("fail to send message: %v", err)
+		return err
 	}
-	err = m.ReadMessage(code)
+	err = m.ReadMessage(ctx, code)
 	if err != nil {
-		return errors.New("fail to login")
+		return err
+	}
+	if m.Capabilities == nil {
+		return m.Capability(ctx)
 	}
 	return nil
 }
 
-func (m *MailClient) ListMailBox() error {
-	code, err := m.SendMessage("LIST", "\"\" \"*\"")
+func (m *MailClient) Enable(ctx context.Context, extensions ...string) error {
+	code, err := m.SendMessage(ctx, "ENABLE", strings.Join(extensions, " "))
 	if err != nil {
 		return err
 	}
-	err = m.ReadListMessage(code)
+	content, _, err := m.ParseIMAPContent(ctx, code)
 	if err != nil {
 		return err
 	}
+	for _, resp := range content {
+		if resp.Name != "ENABLED" {
+			continue
+		}
+		for _, extension := range resp.Fields {
+			m.Enabled[strings.ToUpper(extension.Value)] = true
+		}
+	}
 	return nil
 }
 
-func (m *MailClient) FindModSeq() (int, error) {
-	code, err := m.SendMessage("FETCH", fmt.Sprintf("* (MODSEQ)"))
-
+// ListMailBox lists every mailbox, asking for their STATUS in the same
+// command when the server supports LIST-STATUS.
+func (m *MailClient) ListMailBox(ctx context.Context) error {
+	args := `"" "*"`
+	if m.Capabilities.Has(CapListStatus) {
+		args += " RETURN (STATUS " + m.statusItems() + ")"
+	}
+	code, err := m.SendMessage(ctx, "LIST", args)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	seq, err := m.ReadModSeqMessage(code)
+	err = m.ReadListMessage(ctx, code)
 	if err != nil {
-		return 0, err
+		return err
 	}
+	return nil
+}
 
-	return seq, nil
+func (m *MailClient) SelectMailBox(ctx context.Context, mailbox string) error {
+	param := ""
+	if m.Capabilities.Has(CapCondStore) {
+		param = " (CONDSTORE)"
+	}
+	if m.Enabled["QRESYNC"] {
+		qresyncParam, err := m.qresyncParameter(ctx, mailbox)
+		if err != nil {
+			return err
+		}
+		if qresyncParam != "" {
+			param = " " + qresyncParam
+		}
+	}
+	code, err := m.SendMessage(ctx, "SELECT", quoteString(mailbox)+param)
+	if err != nil {
+		return err
+	}
+	err = m.ReadSelectMessage(ctx, mailbox, code)
+	if err != nil {
+		return err
+	}
+	m.CurrentMailBox = mailbox
+	return nil
 }
 
-func (m *MailClient) FindUpdatedEmail(seq int) ([]int, error) {
-	code, err := m.SendMessage("SEARCH", fmt.Sprintf("MODSEQ %d", seq))
+func (m *MailClient) qresyncParameter(ctx context.Context, mailbox string) (string, error) {
+	cached, err := m.CacheRepository.GetCategory(ctx, mailbox)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	err = m.ReadMessage(code)
+	if cached.Uidvalidity == 0 || cached.Modseq == 0 {
+		return "", nil
+	}
+	uids, err := m.CacheRepository.ListEmailUIDsByCategory(ctx, cached.ID)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	knownUIDs := []uint32{}
+	for _, uid := range uids {
+		if uid > 0 {
+			knownUIDs = append(knownUIDs, uint32(uid))
+		}
 	}
-	return nil, nil
+	if len(knownUIDs) == 0 {
+		return fmt.Sprintf("(QRESYNC (%d %d))", cached.Uidvalidity, cached.Modseq), nil
+	}
+	return fmt.Sprintf("(QRESYNC (%d %d %s))", cached.Uidvalidity, cached.Modseq, formatSeqSet(knownUIDs)), nil
 }
 
-func (m *MailClient) SelectMailBox(mailbox string) error {
-	code, err := m.SendMessage("SELECT", fmt.Sprintf("\"%s\" (CONDSTORE)", mailbox))
+func (m *MailClient) FetchMail(ctx context.Context, category *Category) error {
+	if category.TotalMails == 0 {
+		return nil
+	}
+	// start := category.TotalMails - ((page - 1) * offset)
+	// end := max(start-offset+1, 1)
+
+	code, err := m.SendMessage(ctx, "UID FETCH", fmt.Sprintf("1:* (%s)", headerFetchItems))
 	if err != nil {
 		return err
 	}
-	err = m.ReadSelectMessage(mailbox, code)
+	err = m.ReadFetchMessage(ctx, category, code)
 	if err != nil {
 		return err
 	}
-	m.CurrentMailBox = mailbox
 	return nil
 }
 
-func (m *MailClient) FetchMail(category *Category) error {
+func (m *MailClient) FetchChangedMail(ctx context.Context, category *Category, modseq int64) error {
 	if category.TotalMails == 0 {
+		category.Mails = []repository.Email{}
 		return nil
 	}
-	// start := category.TotalMails - ((page - 1) * offset)
-	// end := max(start-offset+1, 1)
+	code, err := m.SendMessage(ctx, "UID FETCH", fmt.Sprintf("1:* (MODSEQ %s) (CHANGEDSINCE %d)", headerFetchItems, modseq))
+	if err != nil {
+		return err
+	}
+	return m.ReadFetchMessage(ctx, category, code)
+}
 
-	code, err := m.SendMessage("FETCH", "1:* (BODY[HEADER.FIELDS (SUBJECT FROM DATE)])")
+func (m *MailClient) FetchNewMail(ctx context.Context, category *Category, lastUID uint32) error {
+	if category.TotalMails == 0 {
+		category.Mails = []repository.Email{}
+		return nil
+	}
+	code, err := m.SendMessage(ctx, "UID FETCH", fmt.Sprintf("%d:* (%s)", lastUID+1, headerFetchItems))
 	if err != nil {
 		return err
 	}
-	err = m.ReadFetchMessage(category.Name, code)
+	err = m.ReadFetchMessage(ctx, category, code)
 	if err != nil {
 		return err
 	}
+	// "n:*" always matches the last message, even when its UID is below n.
+	category.Mails = slices.DeleteFunc(category.Mails, func(mail repository.Email) bool {
+		return mail.Uid <= int64(lastUID)
+	})
 	return nil
 }
 
-func (m *MailClient) SendMessage(msgType, msg string) (string, error) {
+func (m *MailClient) SearchUIDs(ctx context.Context, criteria string) ([]uint32, error) {
+	code, err := m.SendMessage(ctx, "UID SEARCH", criteria)
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := m.ParseIMAPContent(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	return findSearchResult(content)
+}
+
+// SendMessage writes a command. Nothing is sent once the connection is
+// broken or when ctx is already done.
+func (m *MailClient) SendMessage(ctx context.Context, msgType, msg string) (string, error) {
+	if err := m.Err(); err != nil {
+		return "", err
+	}
+	m.command = msgType
+	stop, err := m.watch(ctx, true)
+	if err != nil {
+		return "", m.contextError(ctx, err)
+	}
+	defer stop()
 	code := m.NextTag()
 	imapMsg := fmt.Sprintf("%s %s %s\r\n", code, msgType, msg)
-	fmt.Println(imapMsg)
+	if msg == "" {
+		imapMsg = fmt.Sprintf("%s %s\r\n", code, msgType)
+	}
+	m.Tracer.Client(redactCommand(code, msgType, msg))
 	if _, err := m.Writer.WriteString(imapMsg); err != nil {
-		return "", err
+		return "", m.ioError(ctx, err)
 	}
-	return code, m.Writer.Flush()
+	if err := m.Writer.Flush(); err != nil {
+		return "", m.ioError(ctx, err)
+	}
+	return code, nil
 }
 
-func (m *MailClient) ReadSelectMessage(inbox, code string) error {
-	content, _, err := m.ParseIMAPContent(code)
+func (m *MailClient) ReadSelectMessage(ctx context.Context, inbox, code string) error {
+	content, _, err := m.ParseIMAPContent(ctx, code)
 	if err != nil {
 		return err
 	}
-	for _, block := range content {
-		fmt.Println(block)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Emails[inbox]
+	if !ok {
+		return nil
 	}
-	for _, line := range content {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "HIGHESTMODSEQ") {
-			index := strings.Index(line, "HIGHESTMODSEQ")
-			num := line[index+len("HIGHESTMODSEQ")+1 : len(line)-1]
-			totalMails, err := strconv.Atoi(num)
+	for _, resp := range content {
+		switch {
+		case resp.Name == "EXISTS":
+			entry.TotalMails = int(resp.Number)
+		case resp.Name == "OK" && resp.Code == "HIGHESTMODSEQ" && len(resp.CodeArgs) > 0:
+			modseq, err := resp.CodeArgs[0].Int64()
+			if err != nil {
+				return err
+			}
+			entry.HighestModSeq = modseq
+		case resp.Name == "OK" && resp.Code == "UIDNEXT" && len(resp.CodeArgs) > 0:
+			uidNext, err := resp.CodeArgs[0].Uint32()
 			if err != nil {
 				return err
 			}
-			if entry, ok := m.Emails[inbox]; ok {
-				entry.TotalMails = totalMails
-				m.Emails[inbox] = entry
+			entry.UIDNext = uidNext
+		case resp.Name == "OK" && resp.Code == "UIDVALIDITY" && len(resp.CodeArgs) > 0:
+			uidValidity, err := resp.CodeArgs[0].Uint32()
+			if err != nil {
+				return err
 			}
+			entry.UIDValidity = uidValidity
+		case resp.Name == "VANISHED":
+			uids, err := findVanished(resp)
+			if err != nil {
+				return err
+			}
+			entry.Vanished = append(entry.Vanished, uids...)
 		}
 	}
 	return nil
 }
 
-func (m *MailClient) ReadFetchMessage(inbox, code string) error {
-	content, _, err := m.ParseIMAPContent(code)
+func (m *MailClient) ReadFetchMessage(ctx context.Context, category *Category, code string) error {
+	content, _, err := m.ParseIMAPContent(ctx, code)
 	if err != nil {
 		return err
 	}
-	contents := strings.Join(content, "\n")
-	emailContents, err := findEmailContent(contents)
+	emailContents, err := findEmailContent(content)
 	if err != nil {
 		return err
 	}
-	if entry, ok := m.Emails[inbox]; ok {
-		entry.Mails = emailContents
-		m.Emails[inbox] = entry
+	for i := range emailContents {
+		emailContents[i].Uidvalidity = int64(category.UIDValidity)
 	}
+	category.Mails = emailContents
 	return nil
 }
 
-func (m *MailClient) ReadListMessage(code string) error {
-	content, _, err := m.ParseIMAPContent(code)
+func (m *MailClient) ReadListMessage(ctx context.Context, code string) error {
+	content, _, err := m.ParseIMAPContent(ctx, code)
 	if err != nil {
 		return err
 	}
-	contents := strings.Join(content, "\n")
-	categories, err := findEmailBox(contents)
+	categories, err := findEmailBox(content)
 	if err != nil {
 		return err
 	}
-	for _, category := range categories {
-		m.Emails[category] = &Category{Name: category, Mails: []repository.Email{}}
-	}
-	return nil
-}
-
-func (m *MailClient) ReadModSeqMessage(code string) (int, error) {
-	content, _, err := m.ParseIMAPContent(code)
-	if err != nil {
-		return 0, err
-	}
-	latestSeq, err := findModSeq(content)
-	if err != nil {
-		return 0, err
-	}
-	return latestSeq, nil
-}
-
-func (m *MailClient) ReadMessage(code string) error {
-	content, _, err := m.ParseIMAPContent(code)
+	statuses, err := findStatus(content)
 	if err != nil {
 		return err
 	}
-	for _, block := range content {
-		fmt.Println(block)
+	for _, category := range categories {
+		m.Emails[category.Name] = &Category{
+			Name:       category.Name,
+			Delimiter:  category.Delimiter,
+			Attributes: category.Attributes,
+			Role:       mailboxRole(category),
+			Status:     statuses[category.Name],
+			Mails:      []repository.Email{},
+		}
 	}
 	return nil
 }
 
-func (m *MailClient) ParseIMAPContent(code string) ([]string, string, error) {
-	var buffer strings.Builder
+func (m *MailClient) ReadMessage(ctx context.Context, code string) error {
+	_, _, err := m.ParseIMAPContent(ctx, code)
+	return err
+}
+
+func (m *MailClient) ParseIMAPContent(ctx context.Context, code string) ([]*Response, *Response, error) {
+	content := []*Response{}
 	for {
-		line, err := m.Reader.ReadString('\n')
+		resp, err := m.readResponse(ctx)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, "", err
+			return nil, nil, err
 		}
-
-		buffer.WriteString(line)
-		if strings.HasPrefix(line, fmt.Sprintf("%s OK", code)) {
-			break
-		} else if strings.HasPrefix(line, fmt.Sprintf("%s BAD", code)) || strings.HasPrefix(line, fmt.Sprintf("%s NO", code)) {
-			return nil, "", errors.New(fmt.Sprintf("message return bad response: %v", buffer.String()))
+		m.updateCapabilities(resp)
+		if resp.Tag != code {
+			content = append(content, resp)
+			continue
+		}
+		if resp.Name != "OK" {
+			return nil, nil, m.statusError(resp)
 		}
+		return content, resp, nil
 	}
-	content, result := splitContent(code, buffer.String())
-	return content, result, nil
 }
@@ -0,0 +1,322 @@
+package mails
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/milkymilky0116/jellyfish/internal/db"
+	"github.com/milkymilky0116/jellyfish/internal/imaptest"
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+// connect logs in to srv with a client caching to the database at path, so
+// that a second connect with the same path resyncs from the first one's
+// cache.
+func connect(t *testing.T, srv *imaptest.Server, path string) *MailClient {
+	t.Helper()
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newClient(t, conn, path)
+}
+
+func newClient(t *testing.T, conn io.ReadWriter, path string) *MailClient {
+	t.Helper()
+	client, err := openClient(t, conn, path, &ConnConfig{Security: SecurityPlain, AllowInsecureAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// openClient runs NewMailClient with config, closing conn when it fails.
+func openClient(t *testing.T, conn io.ReadWriter, path string, config *ConnConfig) (*MailClient, error) {
+	t.Helper()
+	ctx := context.Background()
+	sqlDB, err := db.InitSqliteDB(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	t.Setenv("IMAP_EMAIL", imaptest.DefaultUsername)
+	t.Setenv("IMAP_PASSWORD", imaptest.DefaultPassword)
+	client, err := NewMailClient(ctx, conn, config, db.NewRepository(sqlDB), nil)
+	if err != nil {
+		if closer, ok := conn.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, err
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, nil
+}
+
+func newServer(t *testing.T) *imaptest.Server {
+	t.Helper()
+	srv := imaptest.NewServer()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dbPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "cache.db")
+}
+
+func appendMail(t *testing.T, srv *imaptest.Server, mailbox, subject string) uint32 {
+	t.Helper()
+	uid, err := srv.Append(mailbox, imaptest.NewMessage("ann@example.org", "bob@example.org", subject, "body of "+subject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uid
+}
+
+func subjects(mails []repository.Email) []string {
+	list := []string{}
+	for _, mail := range mails {
+		list = append(list, mail.Subject)
+	}
+	slices.Sort(list)
+	return list
+}
+
+func findMail(t *testing.T, mails []repository.Email, subject string) repository.Email {
+	t.Helper()
+	for _, mail := range mails {
+		if mail.Subject == subject {
+			return mail
+		}
+	}
+	t.Fatalf("no mail with subject %q in %v", subject, subjects(mails))
+	return repository.Email{}
+}
+
+func sentSince(srv *imaptest.Server, n int) []string {
+	return srv.Commands()[n:]
+}
+
+func hasCommand(commands []string, prefix string) bool {
+	return slices.ContainsFunc(commands, func(command string) bool {
+		return strings.HasPrefix(command, prefix)
+	})
+}
+
+// nextEvent waits for the next idle event, failing the test after a few
+// seconds.
+func nextEvent(t *testing.T, events <-chan IdleEvent) IdleEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("idle events closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an idle event")
+	}
+	return IdleEvent{}
+}
+
+// startIdle runs Idle until the test ends and waits for the IDLE command to
+// reach the server.
+func startIdle(t *testing.T, srv *imaptest.Server, client *MailClient) <-chan IdleEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := client.Idle(ctx)
+	t.Cleanup(func() {
+		cancel()
+		for range events {
+		}
+	})
+	waitCommand(t, srv, "IDLE")
+	return events
+}
+
+func waitCommand(t *testing.T, srv *imaptest.Server, prefix string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		commands := srv.Commands()
+		if len(commands) > 0 && strings.HasPrefix(commands[len(commands)-1], prefix) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server never received %s, got %v", prefix, srv.Commands())
+}
+
+func TestFirstSync(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Trash", `\Trash`)
+	srv.CreateMailBox("Work")
+	srv.CreateMailBox("Work/Reports")
+	srv.Append("INBOX", imaptest.Message{Raw: "From: \"Ann\" <ann@example.org>\n" +
+		"To: bob@example.org\n" +
+		"Subject: =?utf-8?q?h=C3=A9llo?=\n" +
+		"Date: Mon, 2 Jan 2006 15:04:05 -0700\n" +
+		"Content-Type: multipart/alternative; boundary=b1\n\n" +
+		"--b1\nContent-Type: text/plain; charset=utf-8\n\nplain body\n" +
+		"--b1\nContent-Type: text/html\n\n<p>html</p>\n--b1--\n"})
+	appendMail(t, srv, "INBOX", "second")
+	appendMail(t, srv, "Work/Reports", "report")
+
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+
+	names := []string{}
+	for _, category := range client.MailBoxes() {
+		names = append(names, category.Name)
+	}
+	slices.Sort(names)
+	if want := []string{"INBOX", "Trash", "Work", "Work/Reports"}; !slices.Equal(names, want) {
+		t.Errorf("mailboxes = %v, want %v", names, want)
+	}
+	if trash, ok := client.MailBoxByRole(RoleTrash); !ok || trash != "Trash" {
+		t.Errorf("trash = %q, %v", trash, ok)
+	}
+	inbox := client.Mails("INBOX")
+	if got, want := subjects(inbox), []string{"héllo", "second"}; !slices.Equal(got, want) {
+		t.Fatalf("INBOX subjects = %v, want %v", got, want)
+	}
+	if got := subjects(client.Mails("Work/Reports")); !slices.Equal(got, []string{"report"}) {
+		t.Errorf("Work/Reports subjects = %v", got)
+	}
+	mail := findMail(t, inbox, "héllo")
+	if mail.ID == 0 || mail.Uid != 1 || mail.Sender != "Ann <ann@example.org>" || mail.ToAddrs != "bob@example.org" {
+		t.Errorf("mail = %+v", mail)
+	}
+	if mail.BodyStructure == "" {
+		t.Error("BodyStructure is empty")
+	}
+	body, err := client.FetchBody(ctx, "INBOX", mail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "plain body" {
+		t.Errorf("body = %q, want %q", body, "plain body")
+	}
+	if !hasCommand(srv.Commands(), "ENABLE QRESYNC") {
+		t.Errorf("QRESYNC was not enabled: %v", srv.Commands())
+	}
+}
+
+func TestResync(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string
+		// resync is a command only this kind of resync sends.
+		resync string
+	}{
+		{"qresync", imaptest.DefaultCapabilities, `SELECT "INBOX" (QRESYNC`},
+		{"condstore", []string{"IMAP4rev1", "AUTH=PLAIN", "IDLE", "UIDPLUS", "CONDSTORE"}, "UID FETCH 1:* (MODSEQ"},
+		{"plain", []string{"IMAP4rev1", "AUTH=PLAIN", "IDLE"}, "UID SEARCH ALL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.SetCapabilities(tt.capabilities...)
+			for _, subject := range []string{"one", "two", "three"} {
+				appendMail(t, srv, "INBOX", subject)
+			}
+			path := dbPath(t)
+			client := connect(t, srv, path)
+			if got := subjects(client.Mails("INBOX")); len(got) != 3 {
+				t.Fatalf("first sync = %v", got)
+			}
+			ids := map[string]int64{}
+			for _, mail := range client.Mails("INBOX") {
+				ids[mail.Subject] = mail.ID
+			}
+			client.Close()
+
+			srv.SetFlags("INBOX", 1, `\Flagged`, `\Seen`)
+			srv.Expunge("INBOX", 2)
+			appendMail(t, srv, "INBOX", "four")
+			n := len(srv.Commands())
+			client = connect(t, srv, path)
+			commands := sentSince(srv, n)
+			if !hasCommand(commands, tt.resync) {
+				t.Errorf("resync did not send %s: %v", tt.resync, commands)
+			}
+			if hasCommand(commands, "UID FETCH 1:* (UID") {
+				t.Errorf("resync fetched every mail again: %v", commands)
+			}
+			mails := client.Mails("INBOX")
+			if got, want := subjects(mails), []string{"four", "one", "three"}; !slices.Equal(got, want) {
+				t.Fatalf("resynced subjects = %v, want %v", got, want)
+			}
+			one := findMail(t, mails, "one")
+			if !strings.Contains(one.Flags, `\Flagged`) || !strings.Contains(one.Flags, `\Seen`) {
+				t.Errorf("flags of one = %q", one.Flags)
+			}
+			if one.ID != ids["one"] {
+				t.Errorf("cached mail got a new ID: %d, was %d", one.ID, ids["one"])
+			}
+		})
+	}
+}
+
+func TestUIDValidityChange(t *testing.T) {
+	srv := newServer(t)
+	appendMail(t, srv, "INBOX", "old")
+	path := dbPath(t)
+	client := connect(t, srv, path)
+	client.Close()
+
+	srv.Expunge("INBOX", 1)
+	srv.SetUIDValidity("INBOX", 4242)
+	appendMail(t, srv, "INBOX", "new")
+	client = connect(t, srv, path)
+	mails := client.Mails("INBOX")
+	if got := subjects(mails); !slices.Equal(got, []string{"new"}) {
+		t.Fatalf("subjects = %v, want [new]", got)
+	}
+	if mails[0].ID == 0 || mails[0].Uidvalidity != 4242 {
+		t.Errorf("mail = %+v", mails[0])
+	}
+	body, err := client.FetchBody(context.Background(), "INBOX", mails[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "body of new" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestSyncFetchesOutsideTransaction(t *testing.T) {
+	srv := newServer(t)
+	appendMail(t, srv, "INBOX", "old")
+	path := dbPath(t)
+	connect(t, srv, path).Close()
+
+	// The purge after a UIDVALIDITY change is the first write of the sync,
+	// so the cache must stay writable while the new mails are fetched.
+	srv.SetUIDValidity("INBOX", 4242)
+	srv.Inject(imaptest.Failure{Command: "UID FETCH", Response: "OK UID FETCH completed", Delay: 300 * time.Millisecond})
+	other, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	n := len(srv.Commands())
+	written := make(chan error, 1)
+	go func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) && !hasCommand(sentSince(srv, n), "UID FETCH") {
+			time.Sleep(5 * time.Millisecond)
+		}
+		_, err := other.Exec("UPDATE category SET subscribed = subscribed")
+		written <- err
+	}()
+	connect(t, srv, path)
+	if err := <-written; err != nil {
+		t.Errorf("cache locked during the fetch: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package mails
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/milkymilky0116/jellyfish/internal/db"
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+// CopyUID is the UIDPLUS COPYUID response code, mapping source UIDs to the
+// UIDs the messages got in the destination mailbox.
+type CopyUID struct {
+	UIDValidity uint32
+	Source      []uint32
+	Dest        []uint32
+}
+
+func (c *CopyUID) destUID(uid uint32) (uint32, bool) {
+	for i, source := range c.Source {
+		if source == uid && i < len(c.Dest) {
+			return c.Dest[i], true
+		}
+	}
+	return 0, false
+}
+
+func findCopyUID(responses ...*Response) (*CopyUID, error) {
+	for _, resp := range responses {
+		if resp == nil || resp.Code != "COPYUID" || len(resp.CodeArgs) < 3 {
+			continue
+		}
+		uidValidity, err := resp.CodeArgs[0].Uint32()
+		if err != nil {
+			return nil, err
+		}
+		source, err := parseSeqSet(resp.CodeArgs[1].String())
+		if err != nil {
+			return nil, err
+		}
+		dest, err := parseSeqSet(resp.CodeArgs[2].String())
+		if err != nil {
+			return nil, err
+		}
+		return &CopyUID{UIDValidity: uidValidity, Source: source, Dest: dest}, nil
+	}
+	return nil, nil
+}
+
+// MoveMessage moves one message with UID MOVE, or with COPY, STORE \Deleted
+// and EXPUNGE on servers without MOVE. With UIDPLUS the cached email is
+// re-pointed at its new UID instead of being fetched again.
+func (m *MailClient) MoveMessage(ctx context.Context, from, to string, email repository.Email) error {
+	var copyUID *CopyUID
+	err := m.Do(ctx, func() error {
+		if m.CurrentMailBox != from {
+			if err := m.SelectMailBox(ctx, from); err != nil {
+				return err
+			}
+		}
+		var err error
+		if m.Capabilities.Has(CapMove) {
+			copyUID, err = m.uidCommand(ctx, "UID MOVE", fmt.Sprintf("%d %s", email.Uid, quoteString(to)))
+			return err
+		}
+		copyUID, err = m.uidCommand(ctx, "UID COPY", fmt.Sprintf("%d %s", email.Uid, quoteString(to)))
+		if err != nil {
+			return err
+		}
+		return m.expungeMessage(ctx, email)
+	})
+	if err != nil {
+		return err
+	}
+	return m.cacheMove(ctx, from, to, email, copyUID, false)
+}
+
+func (m *MailClient) CopyMessage(ctx context.Context, from, to string, email repository.Email) error {
+	var copyUID *CopyUID
+	err := m.Do(ctx, func() error {
+		if m.CurrentMailBox != from {
+			if err := m.SelectMailBox(ctx, from); err != nil {
+				return err
+			}
+		}
+		var err error
+		copyUID, err = m.uidCommand(ctx, "UID COPY", fmt.Sprintf("%d %s", email.Uid, quoteString(to)))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return m.cacheMove(ctx, from, to, email, copyUID, true)
+}
+
+// TrashMessage moves a message to the Trash mailbox, deleting it for good
+// when it already is in Trash or the server has none.
+func (m *MailClient) TrashMessage(ctx context.Context, mailbox string, email repository.Email) error {
+	if trash, ok := m.MailBoxByRole(RoleTrash); ok && trash != mailbox {
+		return m.MoveMessage(ctx, mailbox, trash, email)
+	}
+	return m.DeleteMessage(ctx, mailbox, email)
+}
+
+// ArchiveMessage moves a message to the mailbox with the \Archive role.
+func (m *MailClient) ArchiveMessage(ctx context.Context, mailbox string, email repository.Email) error {
+	archive, ok := m.MailBoxByRole(RoleArchive)
+	if !ok {
+		return errors.New("no archive mailbox found")
+	}
+	return m.MoveMessage(ctx, mailbox, archive, email)
+}
+
+// DeleteMessage marks one message \Deleted and expunges it.
+func (m *MailClient) DeleteMessage(ctx context.Context, mailbox string, email repository.Email) error {
+	err := m.Do(ctx, func() error {
+		if m.CurrentMailBox != mailbox {
+			if err := m.SelectMailBox(ctx, mailbox); err != nil {
+				return err
+			}
+		}
+		return m.expungeMessage(ctx, email)
+	})
+	if err != nil {
+		return err
+	}
+	return m.cacheMove(ctx, mailbox, "", email, nil, false)
+}
+
+func (m *MailClient) uidCommand(ctx context.Context, command, args string) (*CopyUID, error) {
+	code, err := m.SendMessage(ctx, command, args)
+	if err != nil {
+		return nil, err
+	}
+	content, tagged, err := m.ParseIMAPContent(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	return findCopyUID(append(content, tagged)...)
+}
+
+// expungeMessage removes a single message. Without UIDPLUS only a plain
+// EXPUNGE is available, which also removes other messages already marked
+// \Deleted, as any IMAP client would.
+func (m *MailClient) expungeMessage(ctx context.Context, email repository.Email) error {
+	code, err := m.SendMessage(ctx, "UID STORE", fmt.Sprintf("%d +FLAGS.SILENT (%s)", email.Uid, FlagDeleted))
+	if err != nil {
+		return err
+	}
+	err = m.ReadMessage(ctx, code)
+	if err != nil {
+		return err
+	}
+	if m.Capabilities.Has(CapUIDPlus) {
+		code, err = m.SendMessage(ctx, "UID EXPUNGE", fmt.Sprintf("%d", email.Uid))
+	} else {
+		code, err = m.SendMessage(ctx, "EXPUNGE", "")
+	}
+	if err != nil {
+		return err
+	}
+	return m.ReadMessage(ctx, code)
+}
+
+// cacheMove mirrors a move, copy or delete in the cache. An empty to means
+// the message was deleted; without a COPYUID mapping the destination picks
+// the message up on its next sync.
+func (m *MailClient) cacheMove(ctx context.Context, from, to string, email repository.Email, copyUID *CopyUID, keepSource bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.CacheRepository.WithTx(ctx, func(repo db.IRepository) error {
+		return m.cacheMoveTx(ctx, repo, from, to, email, copyUID, keepSource)
+	})
+}
+
+func (m *MailClient) cacheMoveTx(ctx context.Context, repo db.IRepository, from, to string, email repository.Email, copyUID *CopyUID, keepSource bool) error {
+	source, err := repo.GetCategory(ctx, from)
+	if err != nil {
+		return err
+	}
+	var dest repository.Category
+	var destUID uint32
+	mapped := false
+	if to != "" && copyUID != nil {
+		destUID, mapped = copyUID.destUID(uint32(email.Uid))
+	}
+	if mapped {
+		dest, err = repo.GetCategory(ctx, to)
+		if errors.Is(err, sql.ErrNoRows) {
+			mapped = false
+		} else if err != nil {
+			return err
+		}
+	}
+	switch {
+	case mapped && keepSource:
+		email.Uid, email.Uidvalidity = int64(destUID), int64(copyUID.UIDValidity)
+		if err := m.saveEmail(ctx, repo, dest.ID, email); err != nil {
+			return err
+		}
+	case mapped:
+		updateUIDParam := repository.UpdateEmailUIDParams{
+			Uid:         int64(destUID),
+			Uidvalidity: int64(copyUID.UIDValidity),
+			ID:          email.ID,
+		}
+		if err := repo.UpdateEmailUID(ctx, updateUIDParam); err != nil {
+			return err
+		}
+		moveParam := repository.MoveEmailToCategoryParams{
+			CategoryID:   dest.ID,
+			EmailID:      email.ID,
+			CategoryID_2: source.ID,
+		}
+		if err := repo.MoveEmailToCategory(ctx, moveParam); err != nil {
+			return err
+		}
+	case !keepSource:
+		deleteEmailParam := repository.DeleteEmailByUIDParams{
+			Uid:        email.Uid,
+			CategoryID: source.ID,
+		}
+		if err := repo.DeleteEmailByUID(ctx, deleteEmailParam); err != nil {
+			return err
+		}
+	}
+	if err := m.reloadMails(ctx, repo, from, source.ID); err != nil {
+		return err
+	}
+	if mapped {
+		return m.reloadMails(ctx, repo, to, dest.ID)
+	}
+	return nil
+}
+
+func (m *MailClient) reloadMails(ctx context.Context, repo db.IRepository, mailbox string, categoryID int64) error {
+	category, ok := m.Emails[mailbox]
+	if !ok {
+		return nil
+	}
+	mails, err := repo.ListEmailsByCategory(ctx, categoryID)
+	if err != nil {
+		return err
+	}
+	category.Mails = mails
+	return nil
+}
@@ -0,0 +1,125 @@
+package mails
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestMoveMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string
+		commands     []string
+		// mapped is whether the moved mail shows up in the destination
+		// without syncing it.
+		mapped bool
+	}{
+		{
+			name:         "move",
+			capabilities: []string{"IMAP4rev1", "AUTH=PLAIN", "UIDPLUS", "MOVE"},
+			commands:     []string{`UID MOVE 1 "Archive"`},
+			mapped:       true,
+		},
+		{
+			name:         "copy and uid expunge",
+			capabilities: []string{"IMAP4rev1", "AUTH=PLAIN", "UIDPLUS"},
+			commands:     []string{`UID COPY 1 "Archive"`, `UID STORE 1 +FLAGS.SILENT (\Deleted)`, "UID EXPUNGE 1"},
+			mapped:       true,
+		},
+		{
+			name:         "copy and expunge",
+			capabilities: []string{"IMAP4rev1", "AUTH=PLAIN"},
+			commands:     []string{`UID COPY 1 "Archive"`, `UID STORE 1 +FLAGS.SILENT (\Deleted)`, "EXPUNGE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.SetCapabilities(tt.capabilities...)
+			srv.CreateMailBox("Archive", `\Archive`)
+			appendMail(t, srv, "INBOX", "keep")
+			appendMail(t, srv, "Archive", "archived")
+			client := connect(t, srv, dbPath(t))
+			ctx := context.Background()
+
+			mail := findMail(t, client.Mails("INBOX"), "keep")
+			n := len(srv.Commands())
+			if err := client.ArchiveMessage(ctx, "INBOX", mail); err != nil {
+				t.Fatal(err)
+			}
+			commands := sentSince(srv, n)
+			for _, command := range tt.commands {
+				if !slices.Contains(commands, command) {
+					t.Errorf("%q not sent: %v", command, commands)
+				}
+			}
+			if got := client.Mails("INBOX"); len(got) != 0 {
+				t.Errorf("INBOX still has %v", subjects(got))
+			}
+			if mailbox, _ := srv.MailBox("Archive"); len(mailbox.Messages) != 2 {
+				t.Errorf("server Archive has %d messages, want 2", len(mailbox.Messages))
+			}
+			if mailbox, _ := srv.MailBox("INBOX"); len(mailbox.Messages) != 0 {
+				t.Errorf("server INBOX has %d messages, want 0", len(mailbox.Messages))
+			}
+
+			if tt.mapped {
+				moved := findMail(t, client.Mails("Archive"), "keep")
+				if moved.ID != mail.ID || moved.Uid != 2 {
+					t.Errorf("moved mail = %+v, want ID %d and UID 2", moved, mail.ID)
+				}
+			}
+			if err := client.SyncMailBox(ctx, "Archive"); err != nil {
+				t.Fatal(err)
+			}
+			if got := subjects(client.Mails("Archive")); !slices.Equal(got, []string{"archived", "keep"}) {
+				t.Errorf("Archive subjects = %v", got)
+			}
+		})
+	}
+}
+
+func TestCopyMessage(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	appendMail(t, srv, "INBOX", "copied")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+
+	mail := findMail(t, client.Mails("INBOX"), "copied")
+	if err := client.CopyMessage(ctx, "INBOX", "Work", mail); err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(client.Mails("INBOX")); !slices.Equal(got, []string{"copied"}) {
+		t.Errorf("INBOX subjects = %v", got)
+	}
+	copied := findMail(t, client.Mails("Work"), "copied")
+	if copied.ID == mail.ID || copied.Uid != 1 {
+		t.Errorf("copy = %+v", copied)
+	}
+}
+
+func TestTrashMessage(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Trash", `\Trash`)
+	appendMail(t, srv, "INBOX", "junk")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+
+	mail := findMail(t, client.Mails("INBOX"), "junk")
+	if err := client.TrashMessage(ctx, "INBOX", mail); err != nil {
+		t.Fatal(err)
+	}
+	trashed := findMail(t, client.Mails("Trash"), "junk")
+	// Trashing from Trash deletes for good.
+	if err := client.TrashMessage(ctx, "Trash", trashed); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Mails("Trash"); len(got) != 0 {
+		t.Errorf("Trash still has %v", subjects(got))
+	}
+	if mailbox, _ := srv.MailBox("Trash"); len(mailbox.Messages) != 0 {
+		t.Errorf("server Trash has %d messages", len(mailbox.Messages))
+	}
+}
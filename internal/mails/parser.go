@@ -0,0 +1,330 @@
+package mails
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type responseParser struct {
+	r *bufio.Reader
+}
+
+func ReadResponse(r *bufio.Reader) (*Response, error) {
+	p := &responseParser{r: r}
+	return p.readResponse()
+}
+
+func (p *responseParser) readResponse() (*Response, error) {
+	tag, err := p.readAtom()
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		return nil, p.unexpected("tag")
+	}
+	resp := &Response{Tag: tag}
+	if tag == "+" {
+		text, err := p.readText()
+		resp.Text = strings.TrimPrefix(text, " ")
+		return resp, p.eof(err)
+	}
+	if err := p.expect(' '); err != nil {
+		return nil, err
+	}
+	name, err := p.readAtom()
+	if err != nil {
+		return nil, p.eof(err)
+	}
+	if tag == "*" {
+		if n, err := strconv.ParseUint(name, 10, 32); err == nil {
+			resp.Number = uint32(n)
+			if err := p.expect(' '); err != nil {
+				return nil, err
+			}
+			if name, err = p.readAtom(); err != nil {
+				return nil, p.eof(err)
+			}
+		}
+	}
+	resp.Name = strings.ToUpper(name)
+	switch resp.Name {
+	case "OK", "NO", "BAD", "BYE", "PREAUTH":
+		err = p.readRespText(resp)
+	default:
+		resp.Fields, err = p.readFields(0)
+	}
+	if err != nil {
+		return nil, p.eof(err)
+	}
+	return resp, nil
+}
+
+func (p *responseParser) readRespText(resp *Response) error {
+	b, err := p.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b != ' ' {
+		p.r.UnreadByte()
+		resp.Text, err = p.readText()
+		return err
+	}
+	if b, err = p.peek(); err != nil {
+		return err
+	}
+	if b == '[' {
+		p.r.ReadByte()
+		code, err := p.readAtom()
+		if err != nil {
+			return err
+		}
+		resp.Code = strings.ToUpper(code)
+		resp.CodeArgs, err = p.readFields(']')
+		if err != nil {
+			return err
+		}
+		if b, err = p.peek(); err == nil && b == ' ' {
+			p.r.ReadByte()
+		}
+	}
+	resp.Text, err = p.readText()
+	return err
+}
+
+func (p *responseParser) readFields(end byte) ([]Field, error) {
+	fields := []Field{}
+	for {
+		b, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case b == ' ':
+			p.r.ReadByte()
+			continue
+		case b == '\r' || b == '\n':
+			if end != 0 {
+				return nil, p.unexpected(string(end))
+			}
+			_, err := p.readText()
+			return fields, err
+		case end != 0 && b == end:
+			p.r.ReadByte()
+			return fields, nil
+		}
+		field, err := p.readField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+}
+
+func (p *responseParser) readField() (Field, error) {
+	b, err := p.peek()
+	if err != nil {
+		return Field{}, err
+	}
+	switch b {
+	case '(':
+		p.r.ReadByte()
+		list, err := p.readFields(')')
+		if err != nil {
+			return Field{}, err
+		}
+		return Field{Type: ListField, List: list}, nil
+	case '"':
+		value, err := p.readQuoted()
+		return Field{Type: StringField, Value: value}, err
+	case '{', '~':
+		value, err := p.readLiteral()
+		return Field{Type: StringField, Value: value}, err
+	}
+	atom, err := p.readAtom()
+	if err != nil {
+		return Field{}, err
+	}
+	if atom == "" {
+		return Field{}, p.unexpected("atom")
+	}
+	if strings.EqualFold(atom, "NIL") {
+		return Field{Type: NilField}, nil
+	}
+	return Field{Type: AtomField, Value: atom}, nil
+}
+
+// readAtom reads an atom, keeping bracketed sections such as
+// BODY[HEADER.FIELDS (SUBJECT)] or BODY[]<0> in a single token.
+func (p *responseParser) readAtom() (string, error) {
+	var atom strings.Builder
+	depth := 0
+	for {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && atom.Len() > 0 {
+				return atom.String(), nil
+			}
+			return "", err
+		}
+		switch {
+		case b == '\r' || b == '\n':
+			p.r.UnreadByte()
+			return atom.String(), nil
+		case b == '[':
+			depth++
+		case b == ']':
+			if depth == 0 {
+				p.r.UnreadByte()
+				return atom.String(), nil
+			}
+			depth--
+		case depth > 0:
+		case b == ' ' || b == '(' || b == ')' || b == '"' || b == '{':
+			p.r.UnreadByte()
+			return atom.String(), nil
+		}
+		atom.WriteByte(b)
+	}
+}
+
+func (p *responseParser) readQuoted() (string, error) {
+	if err := p.expect('"'); err != nil {
+		return "", err
+	}
+	var value strings.Builder
+	for {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '"':
+			return value.String(), nil
+		case '\\':
+			if b, err = p.r.ReadByte(); err != nil {
+				return "", err
+			}
+		case '\r', '\n':
+			return "", p.unexpected("closing quote")
+		}
+		value.WriteByte(b)
+	}
+}
+
+func (p *responseParser) readLiteral() (string, error) {
+	b, err := p.r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if b == '~' {
+		if b, err = p.r.ReadByte(); err != nil {
+			return "", err
+		}
+	}
+	if b != '{' {
+		return "", p.unexpected("literal")
+	}
+	size, err := p.r.ReadString('}')
+	if err != nil {
+		return "", err
+	}
+	size = strings.TrimSuffix(strings.TrimSuffix(size, "}"), "+")
+	length, err := strconv.Atoi(size)
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid literal size %q", size)
+	}
+	if line, err := p.readText(); err != nil {
+		return "", err
+	} else if line != "" {
+		return "", p.unexpected("CRLF after literal size")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (p *responseParser) readText() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (p *responseParser) peek() (byte, error) {
+	b, err := p.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (p *responseParser) expect(want byte) error {
+	b, err := p.r.ReadByte()
+	if err != nil {
+		return p.eof(err)
+	}
+	if b != want {
+		p.r.UnreadByte()
+		return p.unexpected(strconv.QuoteRune(rune(want)))
+	}
+	return nil
+}
+
+func (p *responseParser) unexpected(want string) error {
+	rest, _ := p.readText()
+	return fmt.Errorf("malformed response: expected %s near %q", want, rest)
+}
+
+func (p *responseParser) eof(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (f Field) IsNil() bool {
+	return f.Type == NilField
+}
+
+func (f Field) String() string {
+	return f.Value
+}
+
+func (f Field) Uint32() (uint32, error) {
+	if f.Type != AtomField {
+		return 0, fmt.Errorf("expected number, got %q", f.Value)
+	}
+	n, err := strconv.ParseUint(f.Value, 10, 32)
+	return uint32(n), err
+}
+
+func (f Field) Int64() (int64, error) {
+	if f.Type != AtomField {
+		return 0, fmt.Errorf("expected number, got %q", f.Value)
+	}
+	return strconv.ParseInt(f.Value, 10, 64)
+}
+
+func fetchItems(fields []Field) map[string]Field {
+	items := map[string]Field{}
+	if len(fields) == 0 || fields[0].Type != ListField {
+		return items
+	}
+	list := fields[0].List
+	for i := 0; i+1 < len(list); i += 2 {
+		items[strings.ToUpper(list[i].Value)] = list[i+1]
+	}
+	return items
+}
+
+func quoteString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(s) + `"`
+}
@@ -0,0 +1,288 @@
+package mails
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func atom(value string) Field {
+	return Field{Type: AtomField, Value: value}
+}
+
+func str(value string) Field {
+	return Field{Type: StringField, Value: value}
+}
+
+func list(fields ...Field) Field {
+	if fields == nil {
+		fields = []Field{}
+	}
+	return Field{Type: ListField, List: fields}
+}
+
+var nilField = Field{Type: NilField}
+
+func TestReadResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want *Response
+	}{
+		{
+			name: "continuation",
+			line: "+ idling\r\n",
+			want: &Response{Tag: "+", Text: "idling"},
+		},
+		{
+			name: "empty continuation",
+			line: "+\r\n",
+			want: &Response{Tag: "+"},
+		},
+		{
+			name: "tagged ok",
+			line: "A001 OK LOGIN completed\r\n",
+			want: &Response{Tag: "A001", Name: "OK", Text: "LOGIN completed"},
+		},
+		{
+			name: "lowercase status",
+			line: "A002 no [trycreate] Mailbox doesn't exist\r\n",
+			want: &Response{Tag: "A002", Name: "NO", Code: "TRYCREATE", CodeArgs: []Field{}, Text: "Mailbox doesn't exist"},
+		},
+		{
+			name: "code with list argument",
+			line: "* OK [PERMANENTFLAGS (\\Deleted \\Seen \\*)] Limited\r\n",
+			want: &Response{
+				Tag:      "*",
+				Name:     "OK",
+				Code:     "PERMANENTFLAGS",
+				CodeArgs: []Field{list(atom(`\Deleted`), atom(`\Seen`), atom(`\*`))},
+				Text:     "Limited",
+			},
+		},
+		{
+			name: "code with atom arguments",
+			line: "* OK [CAPABILITY IMAP4rev1 IDLE AUTH=PLAIN] ready\r\n",
+			want: &Response{
+				Tag:      "*",
+				Name:     "OK",
+				Code:     "CAPABILITY",
+				CodeArgs: []Field{atom("IMAP4rev1"), atom("IDLE"), atom("AUTH=PLAIN")},
+				Text:     "ready",
+			},
+		},
+		{
+			name: "code without text",
+			line: "A003 OK [READ-WRITE]\r\n",
+			want: &Response{Tag: "A003", Name: "OK", Code: "READ-WRITE", CodeArgs: []Field{}},
+		},
+		{
+			name: "copyuid code",
+			line: "A004 OK [COPYUID 38505 304,319:320 3956:3958] Done\r\n",
+			want: &Response{
+				Tag:      "A004",
+				Name:     "OK",
+				Code:     "COPYUID",
+				CodeArgs: []Field{atom("38505"), atom("304,319:320"), atom("3956:3958")},
+				Text:     "Done",
+			},
+		},
+		{
+			name: "numbered response",
+			line: "* 23 EXISTS\r\n",
+			want: &Response{Tag: "*", Number: 23, Name: "EXISTS", Fields: []Field{}},
+		},
+		{
+			name: "list with nil delimiter",
+			line: "* LIST (\\Noselect) NIL \"\"\r\n",
+			want: &Response{
+				Tag:    "*",
+				Name:   "LIST",
+				Fields: []Field{list(atom(`\Noselect`)), nilField, str("")},
+			},
+		},
+		{
+			name: "list with quoted delimiter",
+			line: "* LIST (\\HasNoChildren \\Sent) \"/\" \"Sent \\\"Items\\\"\"\r\n",
+			want: &Response{
+				Tag:    "*",
+				Name:   "LIST",
+				Fields: []Field{list(atom(`\HasNoChildren`), atom(`\Sent`)), str("/"), str(`Sent "Items"`)},
+			},
+		},
+		{
+			name: "empty list",
+			line: "* FLAGS ()\r\n",
+			want: &Response{Tag: "*", Name: "FLAGS", Fields: []Field{list()}},
+		},
+		{
+			name: "literal with crlf and braces",
+			line: "* 1 FETCH (UID 7 BODY[] {13}\r\n{a}\r\n(b)\r\n\"c\" FLAGS (\\Seen))\r\n",
+			want: &Response{
+				Tag:    "*",
+				Number: 1,
+				Name:   "FETCH",
+				Fields: []Field{list(atom("UID"), atom("7"), atom("BODY[]"), str("{a}\r\n(b)\r\n\"c\""), atom("FLAGS"), list(atom(`\Seen`)))},
+			},
+		},
+		{
+			name: "non-synchronizing literal",
+			line: "* 2 FETCH (BODY[TEXT] {3+}\r\nabc)\r\n",
+			want: &Response{
+				Tag:    "*",
+				Number: 2,
+				Name:   "FETCH",
+				Fields: []Field{list(atom("BODY[TEXT]"), str("abc"))},
+			},
+		},
+		{
+			name: "literal8",
+			line: "* 3 FETCH (BINARY[1] ~{4}\r\n\x00\x01\r\n)\r\n",
+			want: &Response{
+				Tag:    "*",
+				Number: 3,
+				Name:   "FETCH",
+				Fields: []Field{list(atom("BINARY[1]"), str("\x00\x01\r\n"))},
+			},
+		},
+		{
+			name: "empty literal",
+			line: "* 4 FETCH (BODY[] {0}\r\n)\r\n",
+			want: &Response{Tag: "*", Number: 4, Name: "FETCH", Fields: []Field{list(atom("BODY[]"), str(""))}},
+		},
+		{
+			name: "header fields section",
+			line: "* 5 FETCH (BODY[HEADER.FIELDS (SUBJECT FROM)] {17}\r\nSubject: hi\r\n\r\n\r\n)\r\n",
+			want: &Response{
+				Tag:    "*",
+				Number: 5,
+				Name:   "FETCH",
+				Fields: []Field{list(atom("BODY[HEADER.FIELDS (SUBJECT FROM)]"), str("Subject: hi\r\n\r\n\r\n"))},
+			},
+		},
+		{
+			name: "partial section",
+			line: "* 6 FETCH (BODY[1.2]<0> \"part\")\r\n",
+			want: &Response{Tag: "*", Number: 6, Name: "FETCH", Fields: []Field{list(atom("BODY[1.2]<0>"), str("part"))}},
+		},
+		{
+			name: "nested nil and numbers",
+			line: "* 7 FETCH (ENVELOPE (NIL \"subj\" ((\"A\" NIL \"a\" \"x.org\")) NIL) MODSEQ (12345))\r\n",
+			want: &Response{
+				Tag:    "*",
+				Number: 7,
+				Name:   "FETCH",
+				Fields: []Field{list(
+					atom("ENVELOPE"),
+					list(nilField, str("subj"), list(list(str("A"), nilField, str("a"), str("x.org"))), nilField),
+					atom("MODSEQ"),
+					list(atom("12345")),
+				)},
+			},
+		},
+		{
+			name: "bye",
+			line: "* BYE [UNAVAILABLE] shutting down\r\n",
+			want: &Response{Tag: "*", Name: "BYE", Code: "UNAVAILABLE", CodeArgs: []Field{}, Text: "shutting down"},
+		},
+		{
+			name: "bare line feed",
+			line: "* SEARCH 2 3\n",
+			want: &Response{Tag: "*", Name: "SEARCH", Fields: []Field{atom("2"), atom("3")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(tt.line)))
+			if err != nil {
+				t.Fatalf("ReadResponse(%q): %v", tt.line, err)
+			}
+			if !reflect.DeepEqual(resp, tt.want) {
+				t.Errorf("ReadResponse(%q)\n got %#v\nwant %#v", tt.line, resp, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadResponseSequence(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("* 1 FETCH (BODY[] {5}\r\nA1 OK)\r\nA1 OK done\r\n"))
+	resp, err := ReadResponse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Fields[0].List[1].Value; got != "A1 OK" {
+		t.Errorf("literal = %q, want %q", got, "A1 OK")
+	}
+	resp, err = ReadResponse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Tag != "A1" || resp.Name != "OK" || resp.Text != "done" {
+		t.Errorf("second response = %#v", resp)
+	}
+}
+
+func TestReadResponseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"empty", ""},
+		{"missing tag", " OK\r\n"},
+		{"truncated", "* 1 FETCH (UID 1"},
+		{"unclosed list", "* 1 FETCH (UID 1\r\n"},
+		{"unclosed quote", "* LIST () \"/ INBOX\r\n"},
+		{"short literal", "* 1 FETCH (BODY[] {10}\r\nabc"},
+		{"bad literal size", "* 1 FETCH (BODY[] {x}\r\n)\r\n"},
+		{"negative literal size", "* 1 FETCH (BODY[] {-1}\r\n)\r\n"},
+		{"text after literal size", "* 1 FETCH (BODY[] {3} abc\r\n)\r\n"},
+		{"unclosed code", "A1 OK [PERMANENTFLAGS (\\Seen)\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(tt.line)))
+			if err == nil {
+				t.Errorf("ReadResponse(%q) = %#v, want error", tt.line, resp)
+			}
+		})
+	}
+}
+
+func TestFetchItems(t *testing.T) {
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader("* 1 FETCH (uid 9 Flags (\\Seen) BODY[HEADER] NIL)\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := fetchItems(resp.Fields)
+	if uid, err := items["UID"].Uint32(); err != nil || uid != 9 {
+		t.Errorf("UID = %d, %v", uid, err)
+	}
+	if flags := items["FLAGS"]; flags.Type != ListField || len(flags.List) != 1 {
+		t.Errorf("FLAGS = %#v", flags)
+	}
+	if !items["BODY[HEADER]"].IsNil() {
+		t.Errorf("BODY[HEADER] = %#v, want NIL", items["BODY[HEADER]"])
+	}
+}
+
+func TestQuoteString(t *testing.T) {
+	tests := map[string]string{
+		"INBOX":      `"INBOX"`,
+		`a "b" c`:    `"a \"b\" c"`,
+		`back\slash`: `"back\\slash"`,
+		"":           `""`,
+	}
+	for in, want := range tests {
+		if got := quoteString(in); got != want {
+			t.Errorf("quoteString(%q) = %s, want %s", in, got, want)
+		}
+		resp, err := ReadResponse(bufio.NewReader(strings.NewReader("* LIST () NIL " + quoteString(in) + "\r\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Fields[2].Value; got != in {
+			t.Errorf("round trip of %q = %q", in, got)
+		}
+	}
+}
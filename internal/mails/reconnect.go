@@ -0,0 +1,111 @@
+package mails
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"time"
+)
+
+// Reconnection attempts back off exponentially between these delays.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// Reconnect replaces the connection with a new one from Redial, logs in
+// again, brings every mailbox up to date and selects the mailbox that was
+// selected before. Mailboxes are synced from the modseq last written to the
+// cache, so a sync cut short by the drop is picked up where it stopped. When
+// Reconnect fails the connection stays broken.
+func (m *MailClient) Reconnect(ctx context.Context) error {
+	if m.Redial == nil {
+		return errors.New("cannot reconnect without Redial")
+	}
+	mailbox := m.CurrentMailBox
+	err := m.reconnect(ctx, mailbox)
+	if err != nil {
+		m.CurrentMailBox = mailbox
+		m.breakConn(err)
+	}
+	return err
+}
+
+func (m *MailClient) reconnect(ctx context.Context, mailbox string) error {
+	m.Close()
+	conn, err := m.Redial(ctx)
+	if err != nil {
+		return err
+	}
+	m.Conn = conn
+	m.Reader = bufio.NewReader(conn)
+	m.Writer = bufio.NewWriter(conn)
+	m.Capabilities = nil
+	m.Enabled = make(map[string]bool)
+	m.CurrentMailBox = ""
+	m.brokenMu.Lock()
+	m.broken = nil
+	m.brokenMu.Unlock()
+	if err := m.startSession(ctx); err != nil {
+		return err
+	}
+	// The STATUS from before the drop says nothing about what changed since.
+	m.mu.Lock()
+	for _, category := range m.Emails {
+		category.Status = nil
+	}
+	m.mu.Unlock()
+	if err := m.SyncAll(ctx); err != nil {
+		return err
+	}
+	if mailbox == "" {
+		return nil
+	}
+	return m.SelectMailBox(ctx, mailbox)
+}
+
+// reconnectLoop retries Reconnect until it succeeds or ctx is done,
+// reporting every failed attempt on events. Rejected credentials are not
+// retried.
+func (m *MailClient) reconnectLoop(ctx context.Context, events chan<- IdleEvent, requests <-chan commandRequest, mailbox string) error {
+	delay := minReconnectDelay
+	for {
+		err := m.Err()
+		select {
+		case events <- IdleEvent{Mailbox: mailbox, State: Reconnecting, Err: err}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := m.backoff(ctx, delay, requests); err != nil {
+			return err
+		}
+		err = m.Reconnect(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if HasCode(err, CodeAuthenticationFailed) {
+			return err
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// backoff waits for delay. Commands sent through Do in the meantime fail
+// with the error that broke the connection.
+func (m *MailClient) backoff(ctx context.Context, delay time.Duration, requests <-chan commandRequest) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case req := <-requests:
+			req.done <- m.Err()
+		case <-timer.C:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package mails
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/milkymilky0116/jellyfish/internal/imaptest"
+)
+
+func redialer(srv *imaptest.Server) func(ctx context.Context) (io.ReadWriter, error) {
+	return func(ctx context.Context) (io.ReadWriter, error) {
+		return srv.Dial()
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	srv := newServer(t)
+	srv.CreateMailBox("Work")
+	appendMail(t, srv, "INBOX", "one")
+	client := connect(t, srv, dbPath(t))
+	ctx := context.Background()
+	if err := client.Reconnect(ctx); err == nil {
+		t.Fatal("Reconnect without Redial succeeded")
+	}
+	client.Redial = redialer(srv)
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Disconnect()
+	code, err := client.SendMessage(ctx, "NOOP", "")
+	if err == nil {
+		err = client.ReadMessage(ctx, code)
+	}
+	if err == nil || !errors.Is(client.Err(), ErrConnectionBroken) {
+		t.Fatalf("NOOP after disconnect = %v, Err() = %v", err, client.Err())
+	}
+	appendMail(t, srv, "Work", "missed")
+
+	if err := client.Reconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Err(); err != nil {
+		t.Errorf("Err() after Reconnect = %v", err)
+	}
+	if client.CurrentMailBox != "INBOX" {
+		t.Errorf("CurrentMailBox = %q, want INBOX", client.CurrentMailBox)
+	}
+	if err := client.SyncMailBox(ctx, "Work"); err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(client.Mails("Work")); !slices.Equal(got, []string{"missed"}) {
+		t.Errorf("Work subjects = %v", got)
+	}
+}
+
+func TestIdleReconnect(t *testing.T) {
+	srv := newServer(t)
+	appendMail(t, srv, "INBOX", "one")
+	client := connect(t, srv, dbPath(t))
+	client.Redial = redialer(srv)
+	ctx := context.Background()
+	if err := client.SelectMailBox(ctx, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	events := startIdle(t, srv, client)
+
+	srv.Disconnect()
+	appendMail(t, srv, "INBOX", "two")
+	event := nextEvent(t, events)
+	if event.State != Reconnecting || !errors.Is(event.Err, ErrConnectionBroken) {
+		t.Fatalf("event = %+v, want Reconnecting", event)
+	}
+	if err := client.Do(ctx, func() error { return nil }); !errors.Is(err, ErrConnectionBroken) {
+		t.Errorf("Do while reconnecting = %v", err)
+	}
+	event = nextEvent(t, events)
+	if event.State != Connected || event.Err != nil {
+		t.Fatalf("event = %+v, want Connected", event)
+	}
+	if got := subjects(event.Mails); !slices.Equal(got, []string{"one", "two"}) {
+		t.Errorf("subjects = %v", got)
+	}
+
+	waitCommand(t, srv, "IDLE")
+	appendMail(t, srv, "INBOX", "three")
+	if event := nextEvent(t, events); len(event.Mails) != 3 {
+		t.Errorf("event after reconnect = %+v", event)
+	}
+}
+
+func TestIdleReconnectRejected(t *testing.T) {
+	srv := newServer(t)
+	client := connect(t, srv, dbPath(t))
+	client.Redial = redialer(srv)
+	if err := client.SelectMailBox(context.Background(), "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	events := startIdle(t, srv, client)
+
+	srv.SetCredentials(imaptest.DefaultUsername, "changed")
+	srv.Disconnect()
+	if event := nextEvent(t, events); event.State != Reconnecting {
+		t.Fatalf("event = %+v, want Reconnecting", event)
+	}
+	event := nextEvent(t, events)
+	if !HasCode(event.Err, CodeAuthenticationFailed) {
+		t.Fatalf("event = %+v, want AUTHENTICATIONFAILED", event)
+	}
+	if _, ok := <-events; ok {
+		t.Error("Idle kept going after the credentials were rejected")
+	}
+}
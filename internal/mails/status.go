@@ -0,0 +1,161 @@
+package mails
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
+
+func (m *MailClient) statusItems() string {
+	if m.Capabilities.Has(CapCondStore) {
+		return "(MESSAGES UNSEEN UIDNEXT UIDVALIDITY HIGHESTMODSEQ)"
+	}
+	return "(MESSAGES UNSEEN UIDNEXT UIDVALIDITY)"
+}
+
+func (m *MailClient) Status(ctx context.Context, mailbox string) (*MailBoxStatus, error) {
+	code, err := m.SendMessage(ctx, "STATUS", quoteString(mailbox)+" "+m.statusItems())
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := m.ParseIMAPContent(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := findStatus(content)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := statuses[mailbox]
+	if !ok {
+		return nil, errors.New("server sent no STATUS for " + mailbox)
+	}
+	return status, nil
+}
+
+func findStatus(content []*Response) (map[string]*MailBoxStatus, error) {
+	statuses := map[string]*MailBoxStatus{}
+	for _, resp := range content {
+		if resp.Name != "STATUS" || len(resp.Fields) < 2 {
+			continue
+		}
+		status := &MailBoxStatus{Mailbox: resp.Fields[0].String()}
+		items := resp.Fields[1].List
+		for i := 0; i+1 < len(items); i += 2 {
+			var err error
+			switch strings.ToUpper(items[i].Value) {
+			case "MESSAGES":
+				status.Messages, err = items[i+1].Uint32()
+			case "UNSEEN":
+				status.Unseen, err = items[i+1].Uint32()
+			case "UIDNEXT":
+				status.UIDNext, err = items[i+1].Uint32()
+			case "UIDVALIDITY":
+				status.UIDValidity, err = items[i+1].Uint32()
+			case "HIGHESTMODSEQ":
+				status.HighestModSeq, err = items[i+1].Int64()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		statuses[status.Mailbox] = status
+	}
+	return statuses, nil
+}
+
+// SyncAll brings every mailbox up to date. STATUS is asked for before any
+// mailbox is selected, then only mailboxes that changed are synced.
+func (m *MailClient) SyncAll(ctx context.Context) error {
+	m.mu.RLock()
+	names := slices.Sorted(maps.Keys(m.Emails))
+	m.mu.RUnlock()
+	for _, name := range names {
+		m.mu.RLock()
+		category, ok := m.Emails[name]
+		known := ok && category.Status != nil
+		m.mu.RUnlock()
+		if !ok || known {
+			continue
+		}
+		status, err := m.Status(ctx, name)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		category.Status = status
+		m.mu.Unlock()
+	}
+	for _, name := range names {
+		if err := m.RefreshMailBox(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefreshMailBox syncs a mailbox unless its STATUS shows that nothing
+// changed since the last sync, in which case the cached mails are used as
+// they are. Without CONDSTORE a change of flags alone goes unnoticed until
+// the mailbox is synced for another reason.
+func (m *MailClient) RefreshMailBox(ctx context.Context, name string) error {
+	m.mu.RLock()
+	category, ok := m.Emails[name]
+	var status *MailBoxStatus
+	if ok {
+		status = category.Status
+	}
+	m.mu.RUnlock()
+	if status == nil {
+		return m.SyncMailBox(ctx, name)
+	}
+	cached, err := m.CacheRepository.GetCategory(ctx, name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil && statusUnchanged(cached, status) {
+		slog.Debug("mailbox unchanged, skipping sync", "mailbox", name)
+		mails, err := m.CacheRepository.ListEmailsByCategory(ctx, cached.ID)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		category.Mails = mails
+		category.TotalMails = int(status.Messages)
+		category.UIDValidity = status.UIDValidity
+		category.HighestModSeq = cached.Modseq
+		m.mu.Unlock()
+	} else {
+		err = m.SyncMailBox(ctx, name)
+		if err != nil {
+			return err
+		}
+		cached, err = m.CacheRepository.GetCategory(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	updateStatusParam := repository.UpdateCategoryStatusParams{
+		Messages: int64(status.Messages),
+		Unseen:   int64(status.Unseen),
+		Uidnext:  int64(status.UIDNext),
+		ID:       cached.ID,
+	}
+	return m.CacheRepository.UpdateCategoryStatus(ctx, updateStatusParam)
+}
+
+func statusUnchanged(cached repository.Category, status *MailBoxStatus) bool {
+	if cached.Uidnext == 0 || cached.Uidnext != int64(status.UIDNext) {
+		return false
+	}
+	if cached.Uidvalidity != int64(status.UIDValidity) || cached.Messages != int64(status.Messages) {
+		return false
+	}
+	return status.HighestModSeq == 0 || cached.Modseq == status.HighestModSeq
+}
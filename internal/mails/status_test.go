@@ -0,0 +1,88 @@
+package mails
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/milkymilky0116/jellyfish/internal/imaptest"
+)
+
+var statusCapabilities = []struct {
+	name         string
+	capabilities []string
+	command      string
+}{
+	{"list-status", imaptest.DefaultCapabilities, `LIST "" "*" RETURN (STATUS`},
+	{"status", []string{"IMAP4rev1", "AUTH=PLAIN", "CONDSTORE"}, "STATUS"},
+}
+
+func selected(commands []string) []string {
+	mailboxes := []string{}
+	for _, command := range commands {
+		if rest, ok := strings.CutPrefix(command, "SELECT "); ok {
+			mailbox, _, _ := strings.Cut(rest, " ")
+			mailboxes = append(mailboxes, strings.Trim(mailbox, `"`))
+		}
+	}
+	return mailboxes
+}
+
+func TestRefreshUnchanged(t *testing.T) {
+	for _, tt := range statusCapabilities {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.SetCapabilities(tt.capabilities...)
+			srv.CreateMailBox("Work")
+			appendMail(t, srv, "INBOX", "one")
+			appendMail(t, srv, "Work", "two")
+			path := dbPath(t)
+			connect(t, srv, path).Close()
+
+			n := len(srv.Commands())
+			client := connect(t, srv, path)
+			commands := sentSince(srv, n)
+			if !hasCommand(commands, tt.command) {
+				t.Errorf("commands = %v, want %s", commands, tt.command)
+			}
+			if got := selected(commands); len(got) != 0 {
+				t.Errorf("selected %v although nothing changed", got)
+			}
+			if got := subjects(client.Mails("Work")); !slices.Equal(got, []string{"two"}) {
+				t.Errorf("Work subjects = %v", got)
+			}
+		})
+	}
+}
+
+func TestRefreshChanged(t *testing.T) {
+	for _, tt := range statusCapabilities {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.SetCapabilities(tt.capabilities...)
+			srv.CreateMailBox("Work")
+			srv.CreateMailBox("News")
+			appendMail(t, srv, "Work", "old")
+			appendMail(t, srv, "News", "news")
+			path := dbPath(t)
+			connect(t, srv, path).Close()
+
+			// A new mail moves UIDNEXT and a flag change HIGHESTMODSEQ.
+			appendMail(t, srv, "INBOX", "new")
+			srv.SetFlags("Work", 1, `\Seen`)
+			n := len(srv.Commands())
+			client := connect(t, srv, path)
+			got := selected(sentSince(srv, n))
+			slices.Sort(got)
+			if want := []string{"INBOX", "Work"}; !slices.Equal(got, want) {
+				t.Errorf("selected %v, want %v", got, want)
+			}
+			if got := subjects(client.Mails("INBOX")); !slices.Equal(got, []string{"new"}) {
+				t.Errorf("INBOX subjects = %v", got)
+			}
+			if old := findMail(t, client.Mails("Work"), "old"); old.Flags != `\Seen` {
+				t.Errorf("flags of old = %q", old.Flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package mails
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+const redacted = "<redacted>"
+
+// Tracer logs the IMAP conversation. A nil Tracer discards everything, which
+// is the default. Credentials are never written and literal bodies longer
+// than maxLiteral bytes are cut when maxLiteral is positive.
+type Tracer struct {
+	logger     *slog.Logger
+	maxLiteral int
+}
+
+func NewTracer(w io.Writer, maxLiteral int) *Tracer {
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return &Tracer{logger: slog.New(handler), maxLiteral: maxLiteral}
+}
+
+func (t *Tracer) Client(line string) {
+	if t == nil {
+		return
+	}
+	t.logger.Debug("imap", "dir", "C", "line", line)
+}
+
+func (t *Tracer) Server(resp *Response) {
+	if t == nil {
+		return
+	}
+	t.logger.Debug("imap", "dir", "S", "line", t.formatResponse(resp))
+}
+
+func redactCommand(code, msgType, msg string) string {
+	switch strings.ToUpper(msgType) {
+	case "LOGIN":
+		user, _, _ := strings.Cut(msg, " ")
+		return fmt.Sprintf("%s %s %s %s", code, msgType, user, redacted)
+	case "AUTHENTICATE":
+		mechanism, _, hasInitial := strings.Cut(msg, " ")
+		if hasInitial {
+			return fmt.Sprintf("%s %s %s %s", code, msgType, mechanism, redacted)
+		}
+	}
+	if msg == "" {
+		return fmt.Sprintf("%s %s", code, msgType)
+	}
+	return fmt.Sprintf("%s %s %s", code, msgType, msg)
+}
+
+func (t *Tracer) formatResponse(resp *Response) string {
+	var line strings.Builder
+	line.WriteString(resp.Tag)
+	if resp.Tag == "+" {
+		line.WriteString(" " + resp.Text)
+		return line.String()
+	}
+	if resp.Number > 0 {
+		line.WriteString(" " + strconv.FormatUint(uint64(resp.Number), 10))
+	}
+	line.WriteString(" " + resp.Name)
+	if resp.Code != "" {
+		line.WriteString(" [" + resp.Code)
+		for _, arg := range resp.CodeArgs {
+			line.WriteString(" " + t.formatField(arg))
+		}
+		line.WriteString("]")
+	}
+	if resp.Text != "" {
+		line.WriteString(" " + resp.Text)
+	}
+	for _, field := range resp.Fields {
+		line.WriteString(" " + t.formatField(field))
+	}
+	return line.String()
+}
+
+func (t *Tracer) formatField(field Field) string {
+	switch field.Type {
+	case NilField:
+		return "NIL"
+	case ListField:
+		items := make([]string, 0, len(field.List))
+		for _, item := range field.List {
+			items = append(items, t.formatField(item))
+		}
+		return "(" + strings.Join(items, " ") + ")"
+	case StringField:
+		if !strings.ContainsAny(field.Value, "\r\n") && len(field.Value) <= 100 {
+			return quoteString(field.Value)
+		}
+		value := field.Value
+		if t.maxLiteral > 0 && len(value) > t.maxLiteral {
+			value = value[:t.maxLiteral] + fmt.Sprintf("...(%d bytes truncated)", len(field.Value)-t.maxLiteral)
+		}
+		return fmt.Sprintf("{%d}%q", len(field.Value), value)
+	}
+	return field.Value
+}
+
+func (m *MailClient) readResponse(ctx context.Context) (*Response, error) {
+	stop, err := m.watch(ctx, false)
+	if err != nil {
+		return nil, m.ioError(ctx, err)
+	}
+	defer stop()
+	resp, err := m.readRaw()
+	if err != nil {
+		return nil, m.ioError(ctx, err)
+	}
+	return resp, nil
+}
+
+// readRaw reads a response under whatever deadline is already set. Alerts
+// are passed on to Alerts and an untagged BYE is returned as a StatusError.
+func (m *MailClient) readRaw() (*Response, error) {
+	resp, err := ReadResponse(m.Reader)
+	if err != nil {
+		return nil, err
+	}
+	m.Tracer.Server(resp)
+	if resp.Code == CodeAlert {
+		m.alert(resp.Text)
+	}
+	if resp.Tag == "*" && resp.Name == "BYE" {
+		return nil, m.statusError(resp)
+	}
+	return resp, nil
+}
+
+// writeLine sends a raw line such as a continuation or DONE. Lines carrying
+// authentication data are traced as redacted.
+func (m *MailClient) writeLine(ctx context.Context, line string, sensitive bool) error {
+	if sensitive {
+		m.Tracer.Client(redacted)
+	} else {
+		m.Tracer.Client(line)
+	}
+	stop, err := m.watch(ctx, true)
+	if err != nil {
+		return m.ioError(ctx, err)
+	}
+	defer stop()
+	if _, err := m.Writer.WriteString(line + "\r\n"); err != nil {
+		return m.ioError(ctx, err)
+	}
+	if err := m.Writer.Flush(); err != nil {
+		return m.ioError(ctx, err)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package mails
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A transcript holds one IMAP session, one line per entry: "C: " for what
+// the client sent and "S: " for what the server sent, each followed by the
+// raw line Go-quoted so literals and line endings survive. Credentials are
+// redacted; the mail itself is not, so record from a throwaway account.
+
+// Recorder wraps a connection and writes everything passing through it to a
+// transcript.
+type Recorder struct {
+	mu     sync.Mutex
+	conn   io.ReadWriter
+	out    io.Writer
+	client []byte
+	server []byte
+	// authTag is the tag of an AUTHENTICATE in progress, whose continuation
+	// lines carry credentials.
+	authTag string
+}
+
+func NewRecorder(conn io.ReadWriter, out io.Writer) *Recorder {
+	return &Recorder{conn: conn, out: out}
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.server = append(r.server, p[:n]...)
+	if werr := r.flush(false); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	r.client = append(r.client, p...)
+	err := r.flush(false)
+	r.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return r.conn.Write(p)
+}
+
+func (r *Recorder) SetReadDeadline(t time.Time) error {
+	if conn, ok := r.conn.(deadlineConn); ok {
+		return conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (r *Recorder) SetWriteDeadline(t time.Time) error {
+	if conn, ok := r.conn.(deadlineConn); ok {
+		return conn.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// Close writes out any partial lines and closes the connection.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	err := r.flush(true)
+	r.mu.Unlock()
+	if closer, ok := r.conn.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (r *Recorder) flush(partial bool) error {
+	for {
+		line, rest, ok := cutLine(r.client, partial)
+		if !ok {
+			break
+		}
+		r.client = rest
+		if err := r.writeEntry("C", r.redact(line)); err != nil {
+			return err
+		}
+	}
+	for {
+		line, rest, ok := cutLine(r.server, partial)
+		if !ok {
+			break
+		}
+		r.server = rest
+		if r.authTag != "" && strings.HasPrefix(line, r.authTag+" ") {
+			r.authTag = ""
+		}
+		if err := r.writeEntry("S", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) redact(line string) string {
+	if r.authTag != "" {
+		return redacted + "\r\n"
+	}
+	tag, rest, _ := strings.Cut(line, " ")
+	if command, _, _ := strings.Cut(rest, " "); strings.EqualFold(strings.TrimSpace(command), "AUTHENTICATE") {
+		r.authTag = tag
+	}
+	return redactClientLine(line)
+}
+
+func (r *Recorder) writeEntry(direction, line string) error {
+	_, err := fmt.Fprintf(r.out, "%s: %s\n", direction, strconv.Quote(line))
+	return err
+}
+
+func cutLine(buf []byte, partial bool) (string, []byte, bool) {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return string(buf[:i+1]), buf[i+1:], true
+	}
+	if partial && len(buf) > 0 {
+		return string(buf), nil, true
+	}
+	return "", buf, false
+}
+
+// redactClientLine hides the credentials of a LOGIN or of an AUTHENTICATE
+// initial response.
+func redactClientLine(line string) string {
+	tag, rest, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+	command, args, _ := strings.Cut(rest, " ")
+	switch strings.ToUpper(command) {
+	case "LOGIN":
+		return fmt.Sprintf("%s %s %s\r\n", tag, command, redacted)
+	case "AUTHENTICATE":
+		if mechanism, _, hasInitial := strings.Cut(args, " "); hasInitial {
+			return fmt.Sprintf("%s %s %s %s\r\n", tag, command, mechanism, redacted)
+		}
+	}
+	return line
+}
+
+// Replay is a connection that plays the server side of a transcript back.
+// Each command the client sends is answered with the response recorded for
+// the same command on the same selected mailbox, whatever its tag and
+// position, so the order in which mailboxes are synced does not matter. A
+// command that was never recorded fails the write.
+type Replay struct {
+	mu        sync.Mutex
+	ready     *sync.Cond
+	pending   bytes.Buffer
+	client    []byte
+	exchanges map[string][]*exchange
+	current   *exchange
+	tag       string
+	selected  string
+	closed    bool
+}
+
+// exchange is one recorded command. steps[0] is what the server sent after
+// the command, steps[i] what it sent after the i-th continuation line from
+// the client, such as the DONE ending an IDLE.
+type exchange struct {
+	tag   string
+	steps [][]string
+	next  int
+}
+
+func NewReplay(transcript io.Reader) (*Replay, error) {
+	r := &Replay{exchanges: map[string][]*exchange{}}
+	r.ready = sync.NewCond(&r.mu)
+	var current *exchange
+	pendingTag, selected := "", ""
+	scanner := bufio.NewScanner(transcript)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		entry := scanner.Text()
+		if strings.TrimSpace(entry) == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		direction, quoted, ok := strings.Cut(entry, ": ")
+		if !ok || (direction != "C" && direction != "S") {
+			return nil, fmt.Errorf("transcript line %d: expected C: or S:", lineNo)
+		}
+		line, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("transcript line %d: %w", lineNo, err)
+		}
+		switch {
+		case direction == "S" && current == nil:
+			r.pending.WriteString(line)
+		case direction == "S":
+			step := &current.steps[len(current.steps)-1]
+			*step = append(*step, line)
+			if pendingTag != "" && strings.HasPrefix(line, pendingTag+" ") {
+				pendingTag = ""
+			}
+		case pendingTag != "":
+			current.steps = append(current.steps, nil)
+		default:
+			var key, tag string
+			key, tag, selected = replayKey(line, selected)
+			current = &exchange{tag: tag, steps: [][]string{nil}}
+			r.exchanges[key] = append(r.exchanges[key], current)
+			pendingTag = tag
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// replayKey returns the key a command is recorded under, its tag and the
+// mailbox selected after it. Commands that work on the selected mailbox are
+// keyed by that mailbox too.
+func replayKey(line, selected string) (string, string, string) {
+	tag, command, _ := strings.Cut(strings.TrimRight(redactClientLine(line), "\r\n"), " ")
+	name, args, _ := strings.Cut(command, " ")
+	switch strings.ToUpper(name) {
+	case "SELECT", "EXAMINE":
+		return command, tag, firstArg(args)
+	case "CLOSE", "UNSELECT":
+		return selected + " " + command, tag, ""
+	case "FETCH", "STORE", "SEARCH", "COPY", "MOVE", "EXPUNGE", "UID", "IDLE", "NOOP", "CHECK":
+		return selected + " " + command, tag, selected
+	}
+	return command, tag, selected
+}
+
+func firstArg(args string) string {
+	if !strings.HasPrefix(args, `"`) {
+		arg, _, _ := strings.Cut(args, " ")
+		return arg
+	}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case '\\':
+			i++
+		case '"':
+			return args[:i+1]
+		}
+	}
+	return args
+}
+
+func (r *Replay) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.pending.Len() == 0 && !r.closed {
+		r.ready.Wait()
+	}
+	if r.closed {
+		return 0, io.EOF
+	}
+	return r.pending.Read(p)
+}
+
+func (r *Replay) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	r.client = append(r.client, p...)
+	for {
+		line, rest, ok := cutLine(r.client, false)
+		if !ok {
+			break
+		}
+		r.client = rest
+		if err := r.answer(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (r *Replay) answer(line string) error {
+	if r.current == nil || r.current.next >= len(r.current.steps) {
+		key, tag, selected := replayKey(line, r.selected)
+		r.selected = selected
+		if len(r.exchanges[key]) == 0 {
+			key = r.selectFallback(key)
+		}
+		queue := r.exchanges[key]
+		if len(queue) == 0 {
+			return fmt.Errorf("replay: no recorded response to %q", key)
+		}
+		// The last response recorded for a command is reused once the others
+		// are used up, as a command may be sent once more when the replay
+		// syncs mailboxes in another order.
+		r.current, r.tag = queue[0], tag
+		r.current.next = 0
+		if len(queue) > 1 {
+			r.exchanges[key] = queue[1:]
+		}
+	}
+	for _, response := range r.current.steps[r.current.next] {
+		if rest, ok := strings.CutPrefix(response, r.current.tag+" "); ok {
+			response = r.tag + " " + rest
+		}
+		r.pending.WriteString(response)
+	}
+	r.current.next++
+	r.ready.Broadcast()
+	return nil
+}
+
+// selectFallback returns the key of a recorded SELECT or EXAMINE of the
+// same mailbox with other parameters, which differ with the state of the
+// cache.
+func (r *Replay) selectFallback(key string) string {
+	name, args, _ := strings.Cut(key, " ")
+	if !strings.EqualFold(name, "SELECT") && !strings.EqualFold(name, "EXAMINE") {
+		return key
+	}
+	prefix := name + " " + firstArg(args)
+	keys := []string{}
+	for recorded := range r.exchanges {
+		if recorded == prefix || strings.HasPrefix(recorded, prefix+" ") {
+			keys = append(keys, recorded)
+		}
+	}
+	if len(keys) == 0 {
+		return key
+	}
+	slices.Sort(keys)
+	return keys[0]
+}
+
+func (r *Replay) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.ready.Broadcast()
+	return nil
+}
@@ -0,0 +1,56 @@
+package mails
+
+import "strings"
+
+// BuildMailBoxTree nests mailboxes by their hierarchy delimiter. Siblings keep
+// the order of the input, so passing MailBoxes gives a stable tree.
+func BuildMailBoxTree(mailboxes []Category) []*MailBoxNode {
+	roots := []*MailBoxNode{}
+	nodes := map[string]*MailBoxNode{}
+	for i := range mailboxes {
+		mailbox := &mailboxes[i]
+		segments := []string{mailbox.Name}
+		if mailbox.Delimiter != "" {
+			segments = strings.Split(mailbox.Name, mailbox.Delimiter)
+		}
+		siblings := &roots
+		path := ""
+		for depth, segment := range segments {
+			if depth > 0 {
+				path += mailbox.Delimiter
+			}
+			path += segment
+			node, ok := nodes[path]
+			if !ok {
+				name, err := DecodeModifiedUTF7(segment)
+				if err != nil {
+					name = segment
+				}
+				node = &MailBoxNode{Name: name, Path: path}
+				nodes[path] = node
+				*siblings = append(*siblings, node)
+			}
+			if depth == len(segments)-1 {
+				node.Category = mailbox
+			}
+			siblings = &node.Children
+		}
+	}
+	return roots
+}
+
+func (n *MailBoxNode) Unseen() int {
+	if n.Category == nil {
+		return 0
+	}
+	return n.Category.Unseen
+}
+
+// TotalUnseen counts unseen mail in the node and everything below it.
+func (n *MailBoxNode) TotalUnseen() int {
+	total := n.Unseen()
+	for _, child := range n.Children {
+		total += child.TotalUnseen()
+	}
+	return total
+}
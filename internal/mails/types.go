@@ -2,26 +2,182 @@ package mails
 
 import (
 	"bufio"
-	"crypto/tls"
+	"context"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/milkymilky0116/jellyfish/internal/db"
 	"github.com/milkymilky0116/jellyfish/internal/repository"
 )
 
 type MailClient struct {
-	ClienEmail      string
-	ClientPassword  string
-	TagSeq          int
-	Writer          *bufio.Writer
-	Reader          *bufio.Reader
-	Conn            *tls.Conn
-	CurrentMailBox  string
-	Emails          map[string]*Category
-	CacheRepository db.IRepository
+	mu                sync.RWMutex
+	connMu            sync.Mutex
+	idleMu            sync.Mutex
+	requests          chan commandRequest
+	idleStopped       chan struct{}
+	watched           string
+	brokenMu          sync.Mutex
+	broken            error
+	command           string
+	alerts            chan string
+	ClienEmail        string
+	ClientPassword    string
+	AuthMechanism     string
+	TokenSource       TokenSource
+	Server            string
+	AllowInsecureAuth bool
+	Timeout           time.Duration
+	Redial            func(ctx context.Context) (io.ReadWriter, error)
+	Config            *ConnConfig
+	Tracer            *Tracer
+	TagSeq            int
+	Writer            *bufio.Writer
+	Reader            *bufio.Reader
+	Conn              io.ReadWriter
+	CurrentMailBox    string
+	Emails            map[string]*Category
+	Capabilities      CapabilitySet
+	Enabled           map[string]bool
+	CacheRepository   db.IRepository
 }
 
 type Category struct {
-	TotalMails int
+	TotalMails    int
+	HighestModSeq int64
+	UIDValidity   uint32
+	Name          string
+	Subscribed    bool
+	Attributes    []string
+	Role          string
+	Delimiter     string
+	Unseen        int
+	UIDNext       uint32
+	Status        *MailBoxStatus
+	Mails         []repository.Email
+	Vanished      []uint32
+}
+
+// mailboxChanges is what SyncMailBox fetched besides the mails themselves,
+// to be written to the cache once the fetching is done.
+type mailboxChanges struct {
+	// purge replaces every cached mail after a UIDVALIDITY change.
+	purge bool
+	// modseq saves the new HIGHESTMODSEQ after a CHANGEDSINCE fetch.
+	modseq bool
+	// flags are the current flags of the cached mails, for servers without
+	// CONDSTORE.
+	flags []repository.Email
+}
+
+// MailBoxStatus is the answer to STATUS for one mailbox. HighestModSeq is
+// zero when the server does not support CONDSTORE.
+type MailBoxStatus struct {
+	Mailbox       string
+	Messages      uint32
+	Unseen        uint32
+	UIDNext       uint32
+	UIDValidity   uint32
+	HighestModSeq int64
+}
+
+// MailBoxInfo is one mailbox from a LIST or LSUB response.
+type MailBoxInfo struct {
 	Name       string
-	Mails      []repository.Email
+	Delimiter  string
+	Attributes []string
+}
+
+// MailBoxNode is one level of the mailbox hierarchy. Path is the encoded
+// name up to this level; Category is nil for parents the server did not
+// list as selectable, such as \Noselect folders.
+type MailBoxNode struct {
+	Name     string
+	Path     string
+	Category *Category
+	Children []*MailBoxNode
+}
+
+type FieldType int
+
+const (
+	AtomField FieldType = iota
+	StringField
+	ListField
+	NilField
+)
+
+type Field struct {
+	Type  FieldType
+	Value string
+	List  []Field
+}
+
+type Response struct {
+	Tag      string
+	Number   uint32
+	Name     string
+	Code     string
+	CodeArgs []Field
+	Text     string
+	Fields   []Field
+}
+
+type ConnState int
+
+const (
+	Connected ConnState = iota
+	Reconnecting
+)
+
+// IdleEvent reports the refreshed mails of Mailbox. While the connection is
+// being restored State is Reconnecting and Err holds why the connection
+// dropped or why the last attempt failed.
+type IdleEvent struct {
+	Mailbox string
+	Mails   []repository.Email
+	State   ConnState
+	Err     error
+}
+
+// BodyPart is one node of a message's BODYSTRUCTURE. Section is the part
+// specifier used in BODY[section]; it is empty only for a top-level
+// multipart. Message holds the body of an embedded message/rfc822 part.
+type BodyPart struct {
+	Type              string            `json:"type"`
+	Subtype           string            `json:"subtype"`
+	Params            map[string]string `json:"params,omitempty"`
+	ID                string            `json:"id,omitempty"`
+	Description       string            `json:"description,omitempty"`
+	Encoding          string            `json:"encoding,omitempty"`
+	Size              uint32            `json:"size,omitempty"`
+	Lines             uint32            `json:"lines,omitempty"`
+	Disposition       string            `json:"disposition,omitempty"`
+	DispositionParams map[string]string `json:"disposition_params,omitempty"`
+	Language          []string          `json:"language,omitempty"`
+	Section           string            `json:"section,omitempty"`
+	Parts             []*BodyPart       `json:"parts,omitempty"`
+	Message           *BodyPart         `json:"message,omitempty"`
+}
+
+type Address struct {
+	Name    string
+	Mailbox string
+	Host    string
+}
+
+// Envelope is the parsed ENVELOPE fetch item. Date is kept as sent since
+// servers pass malformed values through unchanged.
+type Envelope struct {
+	Date      string
+	Subject   string
+	From      []Address
+	Sender    []Address
+	ReplyTo   []Address
+	To        []Address
+	Cc        []Address
+	Bcc       []Address
+	InReplyTo string
+	MessageID string
 }
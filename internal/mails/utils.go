@@ -1,11 +1,14 @@
 package mails
 
 import (
+	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
-	"net/mail"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode/utf16"
@@ -14,137 +17,188 @@ import (
 	"golang.org/x/net/html/charset"
 )
 
-func findEmailContent(content string) ([]repository.Email, error) {
+func findEmailContent(content []*Response) ([]repository.Email, error) {
 	contents := []repository.Email{}
-	if len(content) > 5 {
-		emailDatas := strings.Split(content, "\n* ")
-		emailDatas[0] = emailDatas[0][1:]
-		for _, block := range emailDatas {
-			idIndex := strings.Index(block, "FETCH")
-			id, err := strconv.Atoi(strings.TrimSpace(block[0:idIndex]))
-			if err != nil {
-				return nil, err
-			}
-			contentLengthStart, end := 0, 0
-			for index, letter := range block {
-				if letter == '{' {
-					contentLengthStart = index
+	for _, resp := range content {
+		if resp.Name != "FETCH" {
+			continue
+		}
+		email := repository.Email{}
+		var envelope *Envelope
+		for key, value := range fetchItems(resp.Fields) {
+			switch key {
+			case "UID":
+				uid, err := value.Uint32()
+				if err != nil {
+					return nil, err
 				}
-				if letter == '}' {
-					end = index
-					contentLength, err := strconv.Atoi(block[contentLengthStart+1 : end])
+				email.Uid = int64(uid)
+			case "ENVELOPE":
+				// A malformed envelope costs the message its headers, not the
+				// whole sync; UID, flags and dates are still kept.
+				parsed, err := ParseEnvelope(value)
+				if err != nil {
+					slog.Debug("skipping malformed ENVELOPE", "err", err)
+					continue
+				}
+				envelope = parsed
+			case "INTERNALDATE":
+				internalDate, err := parseInternalDate(value.String())
+				if err == nil {
+					email.InternalDate = sql.NullTime{Time: internalDate, Valid: true}
+				}
+			case "FLAGS":
+				email.Flags = formatFlags(value)
+			case "MODSEQ":
+				if len(value.List) > 0 {
+					modseq, err := value.List[0].Int64()
 					if err != nil {
 						return nil, err
 					}
-					raw := block[end+1 : end+contentLength]
-					emailContents := strings.Split(raw, "\n")
-					email := repository.Email{}
-					email.Seq = int64(id)
-					var subjectStrBuilder strings.Builder
-					for _, rawLines := range emailContents {
-						rawLines = strings.TrimSpace(rawLines)
-						switch {
-						case strings.HasPrefix(rawLines, "From:"):
-							decodedStr, err := DecodeMimeContent(strings.TrimPrefix(rawLines, "From:"))
-							if err != nil {
-								return nil, err
-							}
-							email.Sender = decodedStr
-						case strings.HasPrefix(rawLines, "Subject:"):
-							decodedStr, err := DecodeMimeContent(strings.TrimPrefix(rawLines, "Subject:"))
-							if err != nil {
-								return nil, err
-							}
-							subjectStrBuilder.WriteString(decodedStr)
-						case strings.HasPrefix(rawLines, "Date:"):
-							parsedTime, err := mail.ParseDate(strings.TrimPrefix(rawLines, "Date: "))
-							if err != nil {
-								return nil, err
-							}
-							email.EmailDate = parsedTime
-						case strings.HasPrefix(rawLines, "FROM:"):
-							decodedStr, err := DecodeMimeContent(strings.TrimPrefix(rawLines, "FROM:"))
-							if err != nil {
-								return nil, err
-							}
-							email.Sender = decodedStr
-						case strings.HasPrefix(rawLines, "SUBJECT:"):
-							decodedStr, err := DecodeMimeContent(strings.TrimPrefix(rawLines, "SUBJECT:"))
-							if err != nil {
-								return nil, err
-							}
-							subjectStrBuilder.WriteString(decodedStr)
-						case strings.HasPrefix(rawLines, "DATE:"):
-							parsedTime, err := mail.ParseDate(strings.TrimPrefix(rawLines, "DATE: "))
-							if err != nil {
-								return nil, err
-							}
-							email.EmailDate = parsedTime
-						default:
-							decodedStr, err := DecodeMimeContent(rawLines)
-							if err != nil {
-								return nil, err
-							}
-							subjectStrBuilder.WriteString(decodedStr)
-						}
-					}
-					email.Subject = subjectStrBuilder.String()
-					contents = append(contents, email)
-					break
+					email.Modseq = modseq
 				}
+			case "RFC822.SIZE":
+				size, err := value.Int64()
+				if err == nil {
+					email.Size = size
+				}
+			case "BODYSTRUCTURE":
+				// Without a structure FetchBody falls back to the whole message.
+				structure, err := ParseBodyStructure(value)
+				if err != nil {
+					slog.Debug("skipping malformed BODYSTRUCTURE", "err", err)
+					continue
+				}
+				encoded, err := json.Marshal(structure)
+				if err != nil {
+					return nil, err
+				}
+				email.BodyStructure = string(encoded)
 			}
-
 		}
+		if envelope != nil {
+			applyEnvelope(&email, envelope)
+		}
+		contents = append(contents, email)
 	}
 	return contents, nil
 }
 
-func findEmailBox(content string) ([]string, error) {
-	categories := []string{}
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		start, end := -1, -1
-		for index, letter := range line {
-			if letter == '(' {
-				start = index
-				for j := start; j < len(line); j++ {
-					if line[j] == ')' {
-						end = j
-						break
-					}
-				}
-				if strings.Contains(line[start:end], "\\Noselect") {
-					continue
-				} else {
-					start, end = -1, len(line)-2
-					for k := end; k >= 0; k-- {
-						if line[k] == '"' {
-							start = k
-							break
-						}
-					}
-					key := line[start+1 : end+1]
-					categories = append(categories, key)
-				}
+func applyEnvelope(email *repository.Email, envelope *Envelope) {
+	from := envelope.From
+	if len(from) == 0 {
+		from = envelope.Sender
+	}
+	if len(from) > 0 {
+		email.Sender = from[0].String()
+	}
+	email.Subject = envelope.Subject
+	email.EmailDate = envelope.ParseDate(email.InternalDate.Time)
+	email.SenderAddr = formatAddressList(envelope.Sender)
+	email.ReplyTo = formatAddressList(envelope.ReplyTo)
+	email.ToAddrs = formatAddressList(envelope.To)
+	email.CcAddrs = formatAddressList(envelope.Cc)
+	email.BccAddrs = formatAddressList(envelope.Bcc)
+	email.MessageID = envelope.MessageID
+	email.InReplyTo = envelope.InReplyTo
+}
+
+func findEmailBox(content []*Response) ([]MailBoxInfo, error) {
+	categories := []MailBoxInfo{}
+	for _, resp := range content {
+		if (resp.Name != "LIST" && resp.Name != "LSUB") || len(resp.Fields) < 3 {
+			continue
+		}
+		info := MailBoxInfo{
+			Name:      resp.Fields[2].String(),
+			Delimiter: resp.Fields[1].String(),
+		}
+		noSelect := false
+		for _, attr := range resp.Fields[0].List {
+			if strings.EqualFold(attr.Value, "\\Noselect") || strings.EqualFold(attr.Value, "\\NonExistent") {
+				noSelect = true
 			}
+			info.Attributes = append(info.Attributes, attr.Value)
 		}
+		if noSelect {
+			continue
+		}
+		categories = append(categories, info)
 	}
 	return categories, nil
 }
-func findModSeq(content []string) (int, error) {
-	for _, line := range content {
-		if len(line) < 2 || !strings.Contains(line, "FETCH") {
+
+func findSearchResult(content []*Response) ([]uint32, error) {
+	uids := []uint32{}
+	for _, resp := range content {
+		if resp.Name != "SEARCH" {
 			continue
 		}
-		index := strings.Index(line, "MODSEQ")
-		result, err := strconv.Atoi(line[index+8 : len(line)-3])
+		for _, field := range resp.Fields {
+			if field.Type != AtomField {
+				continue
+			}
+			uid, err := field.Uint32()
+			if err != nil {
+				return nil, err
+			}
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+func findVanished(resp *Response) ([]uint32, error) {
+	for _, field := range resp.Fields {
+		if field.Type == AtomField {
+			return parseSeqSet(field.Value)
+		}
+	}
+	return nil, nil
+}
+
+func parseSeqSet(set string) ([]uint32, error) {
+	nums := []uint32{}
+	for _, part := range strings.Split(set, ",") {
+		start, end, isRange := strings.Cut(part, ":")
+		first, err := strconv.ParseUint(start, 10, 32)
 		if err != nil {
-			return 0, err
+			return nil, fmt.Errorf("invalid sequence set %q", set)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(end, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid sequence set %q", set)
+			}
+		}
+		if first > last {
+			first, last = last, first
+		}
+		for n := first; n <= last; n++ {
+			nums = append(nums, uint32(n))
+		}
+	}
+	return nums, nil
+}
+
+func formatSeqSet(nums []uint32) string {
+	sorted := slices.Clone(nums)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+	parts := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.FormatUint(uint64(sorted[i]), 10))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%d", sorted[i], sorted[j]))
 		}
-		return result, nil
+		i = j + 1
 	}
-	return 0, nil
+	return strings.Join(parts, ",")
 }
 
 func DecodeModifiedUTF7(s string) (string, error) {
@@ -203,10 +257,41 @@ func DecodeModifiedUTF7(s string) (string, error) {
 	return result.String(), nil
 }
 
+func EncodeModifiedUTF7(s string) string {
+	var result strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if r == '&' {
+			result.WriteString("&-")
+			i++
+			continue
+		}
+		if r >= 0x20 && r <= 0x7e {
+			result.WriteRune(r)
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && (runes[j] < 0x20 || runes[j] > 0x7e) {
+			j++
+		}
+		encoded := utf16.Encode(runes[i:j])
+		raw := make([]byte, 0, len(encoded)*2)
+		for _, unit := range encoded {
+			raw = append(raw, byte(unit>>8), byte(unit))
+		}
+		base64Str := base64.RawStdEncoding.EncodeToString(raw)
+		result.WriteString("&" + strings.ReplaceAll(base64Str, "/", ",") + "-")
+		i = j
+	}
+	return result.String()
+}
+
 func DecodeMimeContent(str string) (string, error) {
 	decoder := mime.WordDecoder{}
 	decoder.CharsetReader = func(encoding string, input io.Reader) (io.Reader, error) {
-		return charset.NewReader(input, encoding)
+		return charset.NewReaderLabel(encoding, input)
 	}
 	decodedStr, err := decoder.DecodeHeader(str)
 	if err != nil {
@@ -214,17 +299,3 @@ func DecodeMimeContent(str string) (string, error) {
 	}
 	return decodedStr, nil
 }
-
-func splitContent(code, content string) ([]string, string) {
-	contentLines := []string{}
-	resultLines := ""
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, code) {
-			resultLines = line
-		} else {
-			contentLines = append(contentLines, line)
-		}
-	}
-	return contentLines, resultLines
-}
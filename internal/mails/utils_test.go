@@ -0,0 +1,35 @@
+package mails
+
+import "testing"
+
+func TestModifiedUTF7(t *testing.T) {
+	tests := []struct {
+		decoded string
+		encoded string
+	}{
+		{"INBOX", "INBOX"},
+		{"~peter/mail/台北/日本語", "~peter/mail/&U,BTFw-/&ZeVnLIqe-"},
+		{"Tom & Jerry", "Tom &- Jerry"},
+		{"&&", "&-&-"},
+		{"Entwürfe", "Entw&APw-rfe"},
+		{"😀 mail", "&2D3eAA- mail"},
+		{"메일함/받은 편지", "&ulTHfNVo-/&vBvHQA- &07jJwA-"},
+	}
+	for _, tt := range tests {
+		if got := EncodeModifiedUTF7(tt.decoded); got != tt.encoded {
+			t.Errorf("EncodeModifiedUTF7(%q) = %q, want %q", tt.decoded, got, tt.encoded)
+		}
+		got, err := DecodeModifiedUTF7(tt.encoded)
+		if err != nil || got != tt.decoded {
+			t.Errorf("DecodeModifiedUTF7(%q) = %q, %v, want %q", tt.encoded, got, err, tt.decoded)
+		}
+	}
+}
+
+func TestDecodeModifiedUTF7Errors(t *testing.T) {
+	for _, encoded := range []string{"&U,BTFw", "&!!!-"} {
+		if got, err := DecodeModifiedUTF7(encoded); err == nil {
+			t.Errorf("DecodeModifiedUTF7(%q) = %q, want an error", encoded, got)
+		}
+	}
+}
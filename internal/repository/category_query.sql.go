@@ -10,17 +10,23 @@ import (
 )
 
 const createCategory = `-- name: CreateCategory :one
-INSERT INTO category (name, key, modseq) VALUES (?, ?, ?) RETURNING id, name, "key", modseq, created_at
+INSERT INTO category (name, key, modseq, uidvalidity) VALUES (?, ?, ?, ?) RETURNING id, name, "key", modseq, created_at, uidvalidity, subscribed, attributes, role, delimiter, messages, unseen, uidnext
 `
 
 type CreateCategoryParams struct {
-	Name   string
-	Key    string
-	Modseq int64
+	Name        string
+	Key         string
+	Modseq      int64
+	Uidvalidity int64
 }
 
 func (q *Queries) CreateCategory(ctx context.Context, arg CreateCategoryParams) (Category, error) {
-	row := q.db.QueryRowContext(ctx, createCategory, arg.Name, arg.Key, arg.Modseq)
+	row := q.db.QueryRowContext(ctx, createCategory,
+		arg.Name,
+		arg.Key,
+		arg.Modseq,
+		arg.Uidvalidity,
+	)
 	var i Category
 	err := row.Scan(
 		&i.ID,
@@ -28,12 +34,29 @@ func (q *Queries) CreateCategory(ctx context.Context, arg CreateCategoryParams)
 		&i.Key,
 		&i.Modseq,
 		&i.CreatedAt,
+		&i.Uidvalidity,
+		&i.Subscribed,
+		&i.Attributes,
+		&i.Role,
+		&i.Delimiter,
+		&i.Messages,
+		&i.Unseen,
+		&i.Uidnext,
 	)
 	return i, err
 }
 
+const deleteCategory = `-- name: DeleteCategory :exec
+DELETE FROM category WHERE id = ?
+`
+
+func (q *Queries) DeleteCategory(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteCategory, id)
+	return err
+}
+
 const getCategory = `-- name: GetCategory :one
-SELECT id, name, "key", modseq, created_at FROM category WHERE key = ? LIMIT 1
+SELECT id, name, "key", modseq, created_at, uidvalidity, subscribed, attributes, role, delimiter, messages, unseen, uidnext FROM category WHERE key = ? LIMIT 1
 `
 
 func (q *Queries) GetCategory(ctx context.Context, key string) (Category, error) {
@@ -45,6 +68,141 @@ func (q *Queries) GetCategory(ctx context.Context, key string) (Category, error)
 		&i.Key,
 		&i.Modseq,
 		&i.CreatedAt,
+		&i.Uidvalidity,
+		&i.Subscribed,
+		&i.Attributes,
+		&i.Role,
+		&i.Delimiter,
+		&i.Messages,
+		&i.Unseen,
+		&i.Uidnext,
 	)
 	return i, err
 }
+
+const listCategories = `-- name: ListCategories :many
+SELECT id, name, "key", modseq, created_at, uidvalidity, subscribed, attributes, role, delimiter, messages, unseen, uidnext FROM category ORDER BY key
+`
+
+func (q *Queries) ListCategories(ctx context.Context) ([]Category, error) {
+	rows, err := q.db.QueryContext(ctx, listCategories)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Category
+	for rows.Next() {
+		var i Category
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Key,
+			&i.Modseq,
+			&i.CreatedAt,
+			&i.Uidvalidity,
+			&i.Subscribed,
+			&i.Attributes,
+			&i.Role,
+			&i.Delimiter,
+			&i.Messages,
+			&i.Unseen,
+			&i.Uidnext,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const renameCategory = `-- name: RenameCategory :exec
+UPDATE category SET name = ?, key = ? WHERE id = ?
+`
+
+type RenameCategoryParams struct {
+	Name string
+	Key  string
+	ID   int64
+}
+
+func (q *Queries) RenameCategory(ctx context.Context, arg RenameCategoryParams) error {
+	_, err := q.db.ExecContext(ctx, renameCategory, arg.Name, arg.Key, arg.ID)
+	return err
+}
+
+const setCategorySubscribed = `-- name: SetCategorySubscribed :exec
+UPDATE category SET subscribed = ? WHERE key = ?
+`
+
+type SetCategorySubscribedParams struct {
+	Subscribed bool
+	Key        string
+}
+
+func (q *Queries) SetCategorySubscribed(ctx context.Context, arg SetCategorySubscribedParams) error {
+	_, err := q.db.ExecContext(ctx, setCategorySubscribed, arg.Subscribed, arg.Key)
+	return err
+}
+
+const updateCategoryAttributes = `-- name: UpdateCategoryAttributes :exec
+UPDATE category SET attributes = ?, role = ?, delimiter = ? WHERE key = ?
+`
+
+type UpdateCategoryAttributesParams struct {
+	Attributes string
+	Role       string
+	Delimiter  string
+	Key        string
+}
+
+func (q *Queries) UpdateCategoryAttributes(ctx context.Context, arg UpdateCategoryAttributesParams) error {
+	_, err := q.db.ExecContext(ctx, updateCategoryAttributes,
+		arg.Attributes,
+		arg.Role,
+		arg.Delimiter,
+		arg.Key,
+	)
+	return err
+}
+
+const updateCategoryStatus = `-- name: UpdateCategoryStatus :exec
+UPDATE category SET messages = ?, unseen = ?, uidnext = ? WHERE id = ?
+`
+
+type UpdateCategoryStatusParams struct {
+	Messages int64
+	Unseen   int64
+	Uidnext  int64
+	ID       int64
+}
+
+func (q *Queries) UpdateCategoryStatus(ctx context.Context, arg UpdateCategoryStatusParams) error {
+	_, err := q.db.ExecContext(ctx, updateCategoryStatus,
+		arg.Messages,
+		arg.Unseen,
+		arg.Uidnext,
+		arg.ID,
+	)
+	return err
+}
+
+const updateCategorySyncState = `-- name: UpdateCategorySyncState :exec
+UPDATE category SET modseq = ?, uidvalidity = ? WHERE id = ?
+`
+
+type UpdateCategorySyncStateParams struct {
+	Modseq      int64
+	Uidvalidity int64
+	ID          int64
+}
+
+func (q *Queries) UpdateCategorySyncState(ctx context.Context, arg UpdateCategorySyncStateParams) error {
+	_, err := q.db.ExecContext(ctx, updateCategorySyncState, arg.Modseq, arg.Uidvalidity, arg.ID)
+	return err
+}
@@ -9,6 +9,21 @@ import (
 	"context"
 )
 
+const moveEmailToCategory = `-- name: MoveEmailToCategory :exec
+UPDATE email_category SET category_id = ? WHERE email_id = ? AND category_id = ?
+`
+
+type MoveEmailToCategoryParams struct {
+	CategoryID   int64
+	EmailID      int64
+	CategoryID_2 int64
+}
+
+func (q *Queries) MoveEmailToCategory(ctx context.Context, arg MoveEmailToCategoryParams) error {
+	_, err := q.db.ExecContext(ctx, moveEmailToCategory, arg.CategoryID, arg.EmailID, arg.CategoryID_2)
+	return err
+}
+
 const registerEmailAndCategory = `-- name: RegisterEmailAndCategory :exec
 INSERT INTO email_category (email_id, category_id) VALUES (?, ?)
 `
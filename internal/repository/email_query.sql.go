@@ -7,41 +7,110 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
 const createEmail = `-- name: CreateEmail :one
-INSERT INTO email (seq, sender, subject, email_date) VALUES (?, ?, ?, ?) RETURNING id, seq, sender, subject, email_date, created_at
+INSERT INTO email (
+  uid, uidvalidity, sender, subject, email_date, body_structure,
+  sender_addr, reply_to, to_addrs, cc_addrs, bcc_addrs, message_id, in_reply_to, internal_date, size,
+  flags, modseq
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, sender, subject, email_date, created_at, uid, uidvalidity, body_structure, sender_addr, reply_to, to_addrs, cc_addrs, bcc_addrs, message_id, in_reply_to, internal_date, size, flags, modseq
 `
 
 type CreateEmailParams struct {
-	Seq       int64
-	Sender    string
-	Subject   string
-	EmailDate time.Time
+	Uid           int64
+	Uidvalidity   int64
+	Sender        string
+	Subject       string
+	EmailDate     time.Time
+	BodyStructure string
+	SenderAddr    string
+	ReplyTo       string
+	ToAddrs       string
+	CcAddrs       string
+	BccAddrs      string
+	MessageID     string
+	InReplyTo     string
+	InternalDate  sql.NullTime
+	Size          int64
+	Flags         string
+	Modseq        int64
 }
 
 func (q *Queries) CreateEmail(ctx context.Context, arg CreateEmailParams) (Email, error) {
 	row := q.db.QueryRowContext(ctx, createEmail,
-		arg.Seq,
+		arg.Uid,
+		arg.Uidvalidity,
 		arg.Sender,
 		arg.Subject,
 		arg.EmailDate,
+		arg.BodyStructure,
+		arg.SenderAddr,
+		arg.ReplyTo,
+		arg.ToAddrs,
+		arg.CcAddrs,
+		arg.BccAddrs,
+		arg.MessageID,
+		arg.InReplyTo,
+		arg.InternalDate,
+		arg.Size,
+		arg.Flags,
+		arg.Modseq,
 	)
 	var i Email
 	err := row.Scan(
 		&i.ID,
-		&i.Seq,
 		&i.Sender,
 		&i.Subject,
 		&i.EmailDate,
 		&i.CreatedAt,
+		&i.Uid,
+		&i.Uidvalidity,
+		&i.BodyStructure,
+		&i.SenderAddr,
+		&i.ReplyTo,
+		&i.ToAddrs,
+		&i.CcAddrs,
+		&i.BccAddrs,
+		&i.MessageID,
+		&i.InReplyTo,
+		&i.InternalDate,
+		&i.Size,
+		&i.Flags,
+		&i.Modseq,
 	)
 	return i, err
 }
 
+const deleteCategoryEmails = `-- name: DeleteCategoryEmails :exec
+DELETE FROM email
+WHERE id IN (SELECT email_id FROM email_category WHERE category_id = ?)
+`
+
+func (q *Queries) DeleteCategoryEmails(ctx context.Context, categoryID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteCategoryEmails, categoryID)
+	return err
+}
+
+const deleteEmailByUID = `-- name: DeleteEmailByUID :exec
+DELETE FROM email
+WHERE uid = ? AND id IN (SELECT email_id FROM email_category WHERE category_id = ?)
+`
+
+type DeleteEmailByUIDParams struct {
+	Uid        int64
+	CategoryID int64
+}
+
+func (q *Queries) DeleteEmailByUID(ctx context.Context, arg DeleteEmailByUIDParams) error {
+	_, err := q.db.ExecContext(ctx, deleteEmailByUID, arg.Uid, arg.CategoryID)
+	return err
+}
+
 const getEmailById = `-- name: GetEmailById :one
-SELECT id, seq, sender, subject, email_date, created_at FROM email WHERE id = ? LIMIT 1
+SELECT id, sender, subject, email_date, created_at, uid, uidvalidity, body_structure, sender_addr, reply_to, to_addrs, cc_addrs, bcc_addrs, message_id, in_reply_to, internal_date, size, flags, modseq FROM email WHERE id = ? LIMIT 1
 `
 
 func (q *Queries) GetEmailById(ctx context.Context, id int64) (Email, error) {
@@ -49,11 +118,267 @@ func (q *Queries) GetEmailById(ctx context.Context, id int64) (Email, error) {
 	var i Email
 	err := row.Scan(
 		&i.ID,
-		&i.Seq,
 		&i.Sender,
 		&i.Subject,
 		&i.EmailDate,
 		&i.CreatedAt,
+		&i.Uid,
+		&i.Uidvalidity,
+		&i.BodyStructure,
+		&i.SenderAddr,
+		&i.ReplyTo,
+		&i.ToAddrs,
+		&i.CcAddrs,
+		&i.BccAddrs,
+		&i.MessageID,
+		&i.InReplyTo,
+		&i.InternalDate,
+		&i.Size,
+		&i.Flags,
+		&i.Modseq,
+	)
+	return i, err
+}
+
+const getEmailByUID = `-- name: GetEmailByUID :one
+SELECT email.id, email.sender, email.subject, email.email_date, email.created_at, email.uid, email.uidvalidity, email.body_structure, email.sender_addr, email.reply_to, email.to_addrs, email.cc_addrs, email.bcc_addrs, email.message_id, email.in_reply_to, email.internal_date, email.size, email.flags, email.modseq FROM email
+JOIN email_category ON email_category.email_id = email.id
+WHERE email_category.category_id = ? AND email.uidvalidity = ? AND email.uid = ? LIMIT 1
+`
+
+type GetEmailByUIDParams struct {
+	CategoryID  int64
+	Uidvalidity int64
+	Uid         int64
+}
+
+func (q *Queries) GetEmailByUID(ctx context.Context, arg GetEmailByUIDParams) (Email, error) {
+	row := q.db.QueryRowContext(ctx, getEmailByUID, arg.CategoryID, arg.Uidvalidity, arg.Uid)
+	var i Email
+	err := row.Scan(
+		&i.ID,
+		&i.Sender,
+		&i.Subject,
+		&i.EmailDate,
+		&i.CreatedAt,
+		&i.Uid,
+		&i.Uidvalidity,
+		&i.BodyStructure,
+		&i.SenderAddr,
+		&i.ReplyTo,
+		&i.ToAddrs,
+		&i.CcAddrs,
+		&i.BccAddrs,
+		&i.MessageID,
+		&i.InReplyTo,
+		&i.InternalDate,
+		&i.Size,
+		&i.Flags,
+		&i.Modseq,
 	)
 	return i, err
 }
+
+const listEmailUIDsByCategory = `-- name: ListEmailUIDsByCategory :many
+SELECT email.uid FROM email
+JOIN email_category ON email_category.email_id = email.id
+WHERE email_category.category_id = ?
+ORDER BY email.uid
+`
+
+func (q *Queries) ListEmailUIDsByCategory(ctx context.Context, categoryID int64) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, listEmailUIDsByCategory, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var uid int64
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		items = append(items, uid)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEmailsByCategory = `-- name: ListEmailsByCategory :many
+SELECT email.id, email.sender, email.subject, email.email_date, email.created_at, email.uid, email.uidvalidity, email.body_structure, email.sender_addr, email.reply_to, email.to_addrs, email.cc_addrs, email.bcc_addrs, email.message_id, email.in_reply_to, email.internal_date, email.size, email.flags, email.modseq FROM email
+JOIN email_category ON email_category.email_id = email.id
+WHERE email_category.category_id = ?
+ORDER BY email.uid DESC
+`
+
+func (q *Queries) ListEmailsByCategory(ctx context.Context, categoryID int64) ([]Email, error) {
+	rows, err := q.db.QueryContext(ctx, listEmailsByCategory, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Email
+	for rows.Next() {
+		var i Email
+		if err := rows.Scan(
+			&i.ID,
+			&i.Sender,
+			&i.Subject,
+			&i.EmailDate,
+			&i.CreatedAt,
+			&i.Uid,
+			&i.Uidvalidity,
+			&i.BodyStructure,
+			&i.SenderAddr,
+			&i.ReplyTo,
+			&i.ToAddrs,
+			&i.CcAddrs,
+			&i.BccAddrs,
+			&i.MessageID,
+			&i.InReplyTo,
+			&i.InternalDate,
+			&i.Size,
+			&i.Flags,
+			&i.Modseq,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeStaleEmails = `-- name: PurgeStaleEmails :exec
+DELETE FROM email
+WHERE uidvalidity != ? AND id IN (SELECT email_id FROM email_category WHERE category_id = ?)
+`
+
+type PurgeStaleEmailsParams struct {
+	Uidvalidity int64
+	CategoryID  int64
+}
+
+func (q *Queries) PurgeStaleEmails(ctx context.Context, arg PurgeStaleEmailsParams) error {
+	_, err := q.db.ExecContext(ctx, purgeStaleEmails, arg.Uidvalidity, arg.CategoryID)
+	return err
+}
+
+const updateEmail = `-- name: UpdateEmail :one
+UPDATE email SET
+  sender = ?, subject = ?, email_date = ?, body_structure = ?,
+  sender_addr = ?, reply_to = ?, to_addrs = ?, cc_addrs = ?, bcc_addrs = ?,
+  message_id = ?, in_reply_to = ?, internal_date = ?, size = ?,
+  flags = ?, modseq = ?
+WHERE id = ? RETURNING id, sender, subject, email_date, created_at, uid, uidvalidity, body_structure, sender_addr, reply_to, to_addrs, cc_addrs, bcc_addrs, message_id, in_reply_to, internal_date, size, flags, modseq
+`
+
+type UpdateEmailParams struct {
+	Sender        string
+	Subject       string
+	EmailDate     time.Time
+	BodyStructure string
+	SenderAddr    string
+	ReplyTo       string
+	ToAddrs       string
+	CcAddrs       string
+	BccAddrs      string
+	MessageID     string
+	InReplyTo     string
+	InternalDate  sql.NullTime
+	Size          int64
+	Flags         string
+	Modseq        int64
+	ID            int64
+}
+
+func (q *Queries) UpdateEmail(ctx context.Context, arg UpdateEmailParams) (Email, error) {
+	row := q.db.QueryRowContext(ctx, updateEmail,
+		arg.Sender,
+		arg.Subject,
+		arg.EmailDate,
+		arg.BodyStructure,
+		arg.SenderAddr,
+		arg.ReplyTo,
+		arg.ToAddrs,
+		arg.CcAddrs,
+		arg.BccAddrs,
+		arg.MessageID,
+		arg.InReplyTo,
+		arg.InternalDate,
+		arg.Size,
+		arg.Flags,
+		arg.Modseq,
+		arg.ID,
+	)
+	var i Email
+	err := row.Scan(
+		&i.ID,
+		&i.Sender,
+		&i.Subject,
+		&i.EmailDate,
+		&i.CreatedAt,
+		&i.Uid,
+		&i.Uidvalidity,
+		&i.BodyStructure,
+		&i.SenderAddr,
+		&i.ReplyTo,
+		&i.ToAddrs,
+		&i.CcAddrs,
+		&i.BccAddrs,
+		&i.MessageID,
+		&i.InReplyTo,
+		&i.InternalDate,
+		&i.Size,
+		&i.Flags,
+		&i.Modseq,
+	)
+	return i, err
+}
+
+const updateEmailFlags = `-- name: UpdateEmailFlags :exec
+UPDATE email SET flags = ?, modseq = ?
+WHERE uid = ? AND id IN (SELECT email_id FROM email_category WHERE category_id = ?)
+`
+
+type UpdateEmailFlagsParams struct {
+	Flags      string
+	Modseq     int64
+	Uid        int64
+	CategoryID int64
+}
+
+func (q *Queries) UpdateEmailFlags(ctx context.Context, arg UpdateEmailFlagsParams) error {
+	_, err := q.db.ExecContext(ctx, updateEmailFlags,
+		arg.Flags,
+		arg.Modseq,
+		arg.Uid,
+		arg.CategoryID,
+	)
+	return err
+}
+
+const updateEmailUID = `-- name: UpdateEmailUID :exec
+UPDATE email SET uid = ?, uidvalidity = ? WHERE id = ?
+`
+
+type UpdateEmailUIDParams struct {
+	Uid         int64
+	Uidvalidity int64
+	ID          int64
+}
+
+func (q *Queries) UpdateEmailUID(ctx context.Context, arg UpdateEmailUIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateEmailUID, arg.Uid, arg.Uidvalidity, arg.ID)
+	return err
+}
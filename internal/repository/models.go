@@ -10,20 +10,47 @@ import (
 )
 
 type Category struct {
-	ID        int64
-	Name      string
-	Key       string
-	Modseq    int64
-	CreatedAt sql.NullTime
+	ID          int64
+	Name        string
+	Key         string
+	Modseq      int64
+	CreatedAt   sql.NullTime
+	Uidvalidity int64
+	Subscribed  bool
+	Attributes  string
+	Role        string
+	Delimiter   string
+	Messages    int64
+	Unseen      int64
+	Uidnext     int64
 }
 
 type Email struct {
-	ID        int64
-	Seq       int64
-	Sender    string
-	Subject   string
-	EmailDate time.Time
-	CreatedAt sql.NullTime
+	ID            int64
+	Sender        string
+	Subject       string
+	EmailDate     time.Time
+	CreatedAt     sql.NullTime
+	Uid           int64
+	Uidvalidity   int64
+	BodyStructure string
+	SenderAddr    string
+	ReplyTo       string
+	ToAddrs       string
+	CcAddrs       string
+	BccAddrs      string
+	MessageID     string
+	InReplyTo     string
+	InternalDate  sql.NullTime
+	Size          int64
+	Flags         string
+	Modseq        int64
+}
+
+type EmailBody struct {
+	EmailID   int64
+	Body      string
+	FetchedAt sql.NullTime
 }
 
 type EmailCategory struct {
@@ -1,58 +1,370 @@
 package tui
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/milkymilky0116/jellyfish/internal/mails"
+	"github.com/milkymilky0116/jellyfish/internal/repository"
 )
 
-func InitModel(client *mails.MailClient) (*Model, error) {
-	categoryList := []string{}
-	for key := range client.Emails {
-		decodedKey, err := mails.DecodeModifiedUTF7(key)
-		if err != nil {
-			return nil, err
-		}
-		categoryList = append(categoryList, decodedKey)
-	}
+// InitModel builds the model. Commands started from the TUI run under ctx and
+// are cancelled when the user quits.
+func InitModel(ctx context.Context, client *mails.MailClient, events <-chan mails.IdleEvent) (*Model, error) {
 	categoryPanel := Panel{
 		id:    0,
 		title: "Category",
-		list:  categoryList,
-	}
-	mailList := []string{}
-	for _, mail := range client.Emails["INBOX"].Mails {
-		mailList = append(mailList, mail.Subject)
 	}
+	inbox := client.Mails("INBOX")
+	rows, unread := emailRows(inbox)
 	emailPanel := Panel{
 		id:    1,
 		title: "Email",
-		list:  mailList,
+		list:  rows,
+		bold:  unread,
+	}
+	messagePanel := Panel{
+		id:    2,
+		title: "Message",
+	}
+	model := &Model{
+		Panels:         []Panel{categoryPanel, emailPanel, messagePanel},
+		Client:         client,
+		CurrentMailBox: "INBOX",
+		Mails:          inbox,
+		Events:         events,
+		Collapsed:      map[string]bool{},
 	}
-	return &Model{
-		Panels: []Panel{categoryPanel, emailPanel},
-		Client: client,
-	}, nil
+	model.ctx, model.cancel = context.WithCancel(ctx)
+	model.refreshCategories()
+	return model, nil
 }
 
 func (m Model) Init() tea.Cmd {
+	return tea.Batch(waitForIdleEvent(m.Events), waitForAlert(m.Client.Alerts()))
+}
+
+func waitForIdleEvent(events <-chan mails.IdleEvent) tea.Cmd {
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		event, ok := <-events
+		if !ok {
+			return nil
+		}
+		return event
+	}
+}
+
+// waitForAlert shows the next [ALERT] from the server, which the user has
+// to see.
+func waitForAlert(alerts <-chan string) tea.Cmd {
+	return func() tea.Msg {
+		return alertMsg(<-alerts)
+	}
+}
+
+func fetchBody(ctx context.Context, client *mails.MailClient, mailbox string, email repository.Email) tea.Cmd {
+	return func() tea.Msg {
+		body, err := client.FetchBody(ctx, mailbox, email)
+		if err != nil {
+			return bodyMsg{emailID: email.ID, err: err}
+		}
+		structure, err := mails.EmailStructure(email)
+		if err != nil || structure == nil {
+			return bodyMsg{emailID: email.ID, body: body, err: err}
+		}
+		attachments := []string{}
+		for _, part := range structure.Attachments() {
+			attachments = append(attachments, fmt.Sprintf("%s (%s, %d bytes)", part.Filename(), part.MediaType(), part.Size))
+		}
+		return bodyMsg{emailID: email.ID, body: body, attachments: attachments}
+	}
+}
+
+// visibleCategories flattens the mailbox tree in display order, leaving out
+// the children of collapsed folders.
+func visibleCategories(nodes []*mails.MailBoxNode, collapsed map[string]bool, depth int) ([]*mails.MailBoxNode, []int) {
+	visible := []*mails.MailBoxNode{}
+	depths := []int{}
+	for _, node := range nodes {
+		visible = append(visible, node)
+		depths = append(depths, depth)
+		if !collapsed[node.Path] {
+			children, childDepths := visibleCategories(node.Children, collapsed, depth+1)
+			visible = append(visible, children...)
+			depths = append(depths, childDepths...)
+		}
+	}
+	return visible, depths
+}
+
+// categoryRow renders a folder with its subscription dot, expand marker and
+// unseen count. Collapsed folders count the unseen mail below them too.
+func categoryRow(node *mails.MailBoxNode, depth int, collapsed bool) (string, int) {
+	marker := "  "
+	if node.Category != nil && node.Category.Subscribed {
+		marker = "• "
+	}
+	arrow := "  "
+	if len(node.Children) > 0 {
+		arrow = "▾ "
+		if collapsed {
+			arrow = "▸ "
+		}
+	}
+	unseen := node.Unseen()
+	if collapsed {
+		unseen = node.TotalUnseen()
+	}
+	row := marker + strings.Repeat("  ", depth) + arrow + node.Name
+	if unseen > 0 {
+		row += fmt.Sprintf(" (%d)", unseen)
+	}
+	return row, unseen
+}
+
+func (m *Model) selectedCategory() *mails.MailBoxNode {
+	panel := m.Panels[0]
+	if panel.currentElement >= len(m.Categories) {
+		return nil
+	}
+	return m.Categories[panel.currentElement]
+}
+
+func (m *Model) toggleCategory(collapse bool) {
+	node := m.selectedCategory()
+	if node == nil || len(node.Children) == 0 {
+		return
+	}
+	m.Collapsed[node.Path] = collapse
+	m.refreshCategories()
+}
+
+func mailboxCmd(ctx context.Context, fn func(context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		return mailboxMsg{err: fn(ctx)}
+	}
+}
+
+func (m *Model) categoryPrompt(key string) *Prompt {
+	ctx, client := m.ctx, m.Client
+	node := m.selectedCategory()
+	if key != "n" && (node == nil || node.Category == nil) {
+		return nil
+	}
+	switch key {
+	case "n":
+		return &Prompt{label: "New mailbox: ", submit: func(name string) tea.Cmd {
+			return mailboxCmd(ctx, func(ctx context.Context) error {
+				return client.CreateMailBox(ctx, name)
+			})
+		}}
+	case "R":
+		mailbox := node.Category.Name
+		name, _ := mails.DecodeModifiedUTF7(mailbox)
+		return &Prompt{label: "Rename to: ", input: name, submit: func(name string) tea.Cmd {
+			return mailboxCmd(ctx, func(ctx context.Context) error {
+				return client.RenameMailBox(ctx, mailbox, name)
+			})
+		}}
+	case "D":
+		mailbox := node.Category.Name
+		name, _ := mails.DecodeModifiedUTF7(mailbox)
+		return &Prompt{label: fmt.Sprintf("Delete %s? (y/N): ", name), submit: func(answer string) tea.Cmd {
+			if !strings.EqualFold(answer, "y") {
+				return nil
+			}
+			return mailboxCmd(ctx, func(ctx context.Context) error {
+				return client.DeleteMailBox(ctx, mailbox)
+			})
+		}}
+	}
 	return nil
 }
 
+func (m *Model) emailAction(key string) (*Prompt, tea.Cmd) {
+	ctx, client, mailbox := m.ctx, m.Client, m.CurrentMailBox
+	email := m.Mails[m.Panels[1].currentElement]
+	switch key {
+	case "m":
+		return &Prompt{label: "Move to: ", submit: func(name string) tea.Cmd {
+			return mailboxCmd(ctx, func(ctx context.Context) error {
+				return client.MoveMessage(ctx, mailbox, mails.EncodeModifiedUTF7(name), email)
+			})
+		}}, nil
+	case "a":
+		return nil, mailboxCmd(ctx, func(ctx context.Context) error {
+			return client.ArchiveMessage(ctx, mailbox, email)
+		})
+	case "d":
+		if trash, ok := client.MailBoxByRole(mails.RoleTrash); ok && trash != mailbox {
+			return nil, mailboxCmd(ctx, func(ctx context.Context) error {
+				return client.MoveMessage(ctx, mailbox, trash, email)
+			})
+		}
+		return &Prompt{label: fmt.Sprintf("Delete %q? (y/N): ", email.Subject), submit: func(answer string) tea.Cmd {
+			if !strings.EqualFold(answer, "y") {
+				return nil
+			}
+			return mailboxCmd(ctx, func(ctx context.Context) error {
+				return client.DeleteMessage(ctx, mailbox, email)
+			})
+		}}, nil
+	}
+	return nil, nil
+}
+
+func (m Model) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		prompt := m.Prompt
+		m.Prompt = nil
+		if prompt.input == "" {
+			return m, nil
+		}
+		return m, prompt.submit(prompt.input)
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.Prompt = nil
+	case tea.KeyBackspace:
+		prompt := *m.Prompt
+		runes := []rune(prompt.input)
+		if len(runes) > 0 {
+			prompt.input = string(runes[:len(runes)-1])
+		}
+		m.Prompt = &prompt
+	case tea.KeyRunes, tea.KeySpace:
+		prompt := *m.Prompt
+		prompt.input += string(msg.Runes)
+		m.Prompt = &prompt
+	}
+	return m, nil
+}
+
+func (m *Model) refreshCategories() {
+	mailboxes := m.Client.MailBoxes()
+	nodes, depths := visibleCategories(mails.BuildMailBoxTree(mailboxes), m.Collapsed, 0)
+	list, bold := []string{}, []bool{}
+	for i, node := range nodes {
+		row, unseen := categoryRow(node, depths[i], m.Collapsed[node.Path])
+		list, bold = append(list, row), append(bold, unseen > 0)
+	}
+	m.Categories = nodes
+	m.Panels[0].list, m.Panels[0].bold = list, bold
+	m.Panels[0].currentElement = min(m.Panels[0].currentElement, max(len(list)-1, 0))
+	exists := slices.ContainsFunc(mailboxes, func(category mails.Category) bool {
+		return category.Name == m.CurrentMailBox
+	})
+	if !exists {
+		m.CurrentMailBox = "INBOX"
+		m.setMails(m.Client.Mails(m.CurrentMailBox))
+	}
+}
+
+func storeFlags(ctx context.Context, client *mails.MailClient, mailbox string, email repository.Email, add bool, flag string) tea.Cmd {
+	return func() tea.Msg {
+		updated, err := client.StoreFlags(ctx, mailbox, email, add, flag)
+		return flagsMsg{email: updated, err: err}
+	}
+}
+
+// emailRows renders the email list with a star on flagged mail and reports
+// which rows are unread so they can be drawn in bold.
+func emailRows(emails []repository.Email) ([]string, []bool) {
+	mailList := []string{}
+	unread := []bool{}
+	for _, email := range emails {
+		marker := "  "
+		if mails.HasFlag(email, mails.FlagFlagged) {
+			marker = "★ "
+		}
+		mailList = append(mailList, marker+email.Subject)
+		unread = append(unread, !mails.HasFlag(email, mails.FlagSeen))
+	}
+	return mailList, unread
+}
+
+func (m *Model) setMails(emails []repository.Email) {
+	m.Mails = emails
+	m.Panels[1].list, m.Panels[1].bold = emailRows(emails)
+	m.Panels[1].currentElement = min(m.Panels[1].currentElement, max(len(m.Panels[1].list)-1, 0))
+}
+
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
+	case mails.IdleEvent:
+		if msg.State == mails.Reconnecting {
+			m.Connection = fmt.Sprintf("Reconnecting: %v", msg.Err)
+			return m, waitForIdleEvent(m.Events)
+		}
+		if msg.Err != nil {
+			m.Connection = fmt.Sprintf("Disconnected: %v", msg.Err)
+			return m, nil
+		}
+		m.Connection = ""
+		if msg.Mailbox == m.CurrentMailBox {
+			m.setMails(msg.Mails)
+		}
+		m.refreshCategories()
+		return m, waitForIdleEvent(m.Events)
+	case alertMsg:
+		m.Status = "Server alert: " + string(msg)
+		return m, waitForAlert(m.Client.Alerts())
+	case mailboxMsg:
+		m.Status = ""
+		if msg.err != nil {
+			m.Status = msg.err.Error()
+		}
+		m.refreshCategories()
+		m.setMails(m.Client.Mails(m.CurrentMailBox))
+	case flagsMsg:
+		if msg.err != nil {
+			m.Panels[2].list = []string{msg.err.Error()}
+			break
+		}
+		emails := slices.Clone(m.Mails)
+		for i := range emails {
+			if emails[i].ID == msg.email.ID {
+				emails[i] = msg.email
+			}
+		}
+		m.setMails(emails)
+		m.refreshCategories()
+	case bodyMsg:
+		// The body of an email that was opened before the current one.
+		if msg.emailID != m.OpenedEmail {
+			break
+		}
+		if msg.err != nil {
+			m.Panels[2].list = []string{msg.err.Error()}
+		} else {
+			m.Panels[2].list = strings.Split(msg.body, "\n")
+			if len(msg.attachments) > 0 {
+				m.Panels[2].list = append(m.Panels[2].list, "", "Attachments:")
+				m.Panels[2].list = append(m.Panels[2].list, msg.attachments...)
+			}
+		}
+		m.Panels[2].currentElement = 0
 	case tea.WindowSizeMsg:
-		m.Panels[0].width = msg.Width/4 - 2
-		m.Panels[0].height = msg.Height - 2
-		m.Panels[1].width = (msg.Width / 4 * 3) - 2
-		m.Panels[1].height = msg.Height - 2
+		m.Panels[0].width = msg.Width/5 - 2
+		m.Panels[0].height = msg.Height - 3
+		m.Panels[1].width = (msg.Width / 5 * 2) - 2
+		m.Panels[1].height = msg.Height - 3
+		m.Panels[2].width = (msg.Width / 5 * 2) - 2
+		m.Panels[2].height = msg.Height - 3
 	case tea.KeyMsg:
+		if m.Prompt != nil {
+			return m.updatePrompt(msg)
+		}
 		switch msg.String() {
 		case "q", "ctrl+c":
+			m.cancel()
 			return m, tea.Quit
 		case "tab":
 			m.CurrentPanel = (m.CurrentPanel + 1) % len(m.Panels)
@@ -61,19 +373,92 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			panel := &m.Panels[m.CurrentPanel]
 			switch panel.title {
 			case "Category":
-				emails := m.Client.Emails[panel.list[panel.currentElement]].Mails
-				mailList := []string{}
-				for _, email := range emails {
-					mailList = append(mailList, email.Subject)
+				node := m.selectedCategory()
+				if node == nil {
+					break
+				}
+				if node.Category == nil {
+					m.toggleCategory(!m.Collapsed[node.Path])
+					break
+				}
+				m.CurrentMailBox = node.Category.Name
+				m.Panels[1].currentElement = 0
+				m.setMails(m.Client.Mails(m.CurrentMailBox))
+				client, mailbox := m.Client, m.CurrentMailBox
+				cmd = mailboxCmd(m.ctx, func(ctx context.Context) error {
+					return client.Watch(ctx, mailbox)
+				})
+			case "Email":
+				if len(m.Mails) == 0 {
+					break
 				}
-				m.Panels[1].list = mailList
+				email := m.Mails[panel.currentElement]
+				m.OpenedEmail = email.ID
+				m.Panels[2].list = []string{"Loading..."}
+				m.Panels[2].currentElement = 0
+				cmd = fetchBody(m.ctx, m.Client, m.CurrentMailBox, email)
+				if !mails.HasFlag(email, mails.FlagSeen) {
+					cmd = tea.Batch(cmd, storeFlags(m.ctx, m.Client, m.CurrentMailBox, email, true, mails.FlagSeen))
+				}
+			}
+		case "n", "R", "D":
+			if m.Panels[m.CurrentPanel].title == "Category" {
+				m.Prompt = m.categoryPrompt(msg.String())
+			}
+		case "h", "l", " ":
+			if m.Panels[m.CurrentPanel].title != "Category" {
+				break
 			}
+			collapse := msg.String() == "h"
+			if node := m.selectedCategory(); msg.String() == " " && node != nil {
+				collapse = !m.Collapsed[node.Path]
+			}
+			m.toggleCategory(collapse)
+		case "s":
+			node := m.selectedCategory()
+			if m.Panels[m.CurrentPanel].title != "Category" || node == nil || node.Category == nil {
+				break
+			}
+			client, mailbox, subscribed := m.Client, node.Category.Name, node.Category.Subscribed
+			cmd = mailboxCmd(m.ctx, func(ctx context.Context) error {
+				return client.Subscribe(ctx, mailbox, !subscribed)
+			})
+		case "m", "a", "d":
+			if m.Panels[m.CurrentPanel].title != "Email" || len(m.Mails) == 0 {
+				break
+			}
+			m.Prompt, cmd = m.emailAction(msg.String())
+		case "r", "f":
+			panel := m.Panels[m.CurrentPanel]
+			if panel.title != "Email" || len(m.Mails) == 0 {
+				break
+			}
+			flag := mails.FlagSeen
+			if msg.String() == "f" {
+				flag = mails.FlagFlagged
+			}
+			email := m.Mails[panel.currentElement]
+			cmd = storeFlags(m.ctx, m.Client, m.CurrentMailBox, email, !mails.HasFlag(email, flag), flag)
 		case "j":
 			panel := &m.Panels[m.CurrentPanel]
-			panel.currentElement = (panel.currentElement + 1) % len(panel.list)
+			if len(panel.list) == 0 {
+				break
+			}
+			if panel.title == "Message" {
+				panel.currentElement = min(panel.currentElement+1, len(panel.list)-1)
+			} else {
+				panel.currentElement = (panel.currentElement + 1) % len(panel.list)
+			}
 		case "k":
 			panel := &m.Panels[m.CurrentPanel]
-			panel.currentElement = (len(panel.list) + panel.currentElement - 1) % len(panel.list)
+			if len(panel.list) == 0 {
+				break
+			}
+			if panel.title == "Message" {
+				panel.currentElement = max(panel.currentElement-1, 0)
+			} else {
+				panel.currentElement = (len(panel.list) + panel.currentElement - 1) % len(panel.list)
+			}
 		}
 	}
 	return m, cmd
@@ -88,22 +473,51 @@ func (m Model) View() string {
 			panels = append(panels, renderPanel(panel))
 		}
 	}
-	return lipgloss.JoinHorizontal(lipgloss.Top, panels...)
+	footer := m.Status
+	if m.Connection != "" {
+		footer = strings.TrimSpace(m.Connection + "  " + m.Status)
+	}
+	if m.Prompt != nil {
+		footer = m.Prompt.label + m.Prompt.input
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lipgloss.JoinHorizontal(lipgloss.Top, panels...), footer)
 }
 
 func renderSelectedPanel(panel Panel) string {
+	if panel.title == "Message" {
+		return renderMessagePanel(panel, selectedPanelStyle)
+	}
 	content := fmt.Sprintf("%s\n", panel.title)
 	for index, element := range panel.list {
 		if panel.currentElement == index {
-			content += fmt.Sprintf(">> %s\n", selectedListStyle.Render(element))
+			content += fmt.Sprintf(">> %s\n", rowStyle(selectedListStyle, panel, index).Render(element))
 		} else {
-			content += fmt.Sprintf("%s\n", listStyle.Render(element))
+			content += fmt.Sprintf("%s\n", rowStyle(listStyle, panel, index).Render(element))
 		}
 	}
 	return selectedPanelStyle.Width(panel.width).Height(panel.height).Render(lipgloss.JoinVertical(lipgloss.Center, content))
 }
 
 func renderPanel(panel Panel) string {
-	content := fmt.Sprintf("%s\n%s", panel.title, listStyle.Render(strings.Join(panel.list, "\n")))
+	if panel.title == "Message" {
+		return renderMessagePanel(panel, panelStyle)
+	}
+	rows := []string{}
+	for index, element := range panel.list {
+		rows = append(rows, rowStyle(listStyle, panel, index).Render(element))
+	}
+	content := fmt.Sprintf("%s\n%s", panel.title, strings.Join(rows, "\n"))
 	return panelStyle.Width(panel.width).Height(panel.height).Render(lipgloss.JoinVertical(lipgloss.Center, content))
 }
+
+func rowStyle(style lipgloss.Style, panel Panel, index int) lipgloss.Style {
+	return style.Bold(index < len(panel.bold) && panel.bold[index])
+}
+
+// renderMessagePanel shows the body starting at the scroll offset kept in
+// currentElement, cut to the panel height.
+func renderMessagePanel(panel Panel, style lipgloss.Style) string {
+	lines := panel.list[min(panel.currentElement, len(panel.list)):]
+	content := fmt.Sprintf("%s\n%s", panel.title, strings.Join(lines, "\n"))
+	return style.Width(panel.width).Height(panel.height).MaxHeight(panel.height + 2).Render(content)
+}
@@ -1,19 +1,62 @@
 package tui
 
-import "github.com/milkymilky0116/jellyfish/internal/mails"
+import (
+	"context"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/milkymilky0116/jellyfish/internal/mails"
+	"github.com/milkymilky0116/jellyfish/internal/repository"
+)
 
 type Panel struct {
 	id             int
 	title          string
 	list           []string
+	bold           []bool
 	currentElement int
 	width          int
 	height         int
 }
 
 type Model struct {
-	Panels       []Panel
-	CurrentPanel int
-	CurrentList  []string
-	Client       *mails.MailClient
+	Panels         []Panel
+	CurrentPanel   int
+	CurrentList    []string
+	CurrentMailBox string
+	Mails          []repository.Email
+	Client         *mails.MailClient
+	Events         <-chan mails.IdleEvent
+	Categories     []*mails.MailBoxNode
+	Collapsed      map[string]bool
+	Prompt         *Prompt
+	Status         string
+	Connection     string
+	OpenedEmail    int64
+	ctx            context.Context
+	cancel         context.CancelFunc
+}
+
+// Prompt reads one line of input in the footer and hands it to submit.
+type Prompt struct {
+	label  string
+	input  string
+	submit func(string) tea.Cmd
+}
+
+type alertMsg string
+
+type mailboxMsg struct {
+	err error
+}
+
+type flagsMsg struct {
+	email repository.Email
+	err   error
+}
+
+type bodyMsg struct {
+	emailID     int64
+	body        string
+	attachments []string
+	err         error
 }
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE category ADD COLUMN uidvalidity INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE email ADD COLUMN uid INTEGER NOT NULL DEFAULT 0;
+-- +goose Down
+ALTER TABLE email DROP COLUMN uid;
+ALTER TABLE category DROP COLUMN uidvalidity;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE email ADD COLUMN uidvalidity INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE email DROP COLUMN seq;
+CREATE INDEX email_uid_idx ON email (uidvalidity, uid);
+-- +goose Down
+DROP INDEX email_uid_idx;
+ALTER TABLE email ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE email DROP COLUMN uidvalidity;
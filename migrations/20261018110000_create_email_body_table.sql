@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE email_body (
+  email_id INTEGER PRIMARY KEY,
+  body TEXT NOT NULL,
+  fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (email_id) REFERENCES email (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+-- +goose Down
+DROP TABLE email_body;
@@ -0,0 +1,4 @@
+-- +goose Up
+ALTER TABLE email ADD COLUMN body_structure TEXT NOT NULL DEFAULT '';
+-- +goose Down
+ALTER TABLE email DROP COLUMN body_structure;
@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE email ADD COLUMN sender_addr TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN reply_to TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN to_addrs TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN cc_addrs TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN bcc_addrs TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN message_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN in_reply_to TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN internal_date TIMESTAMP;
+ALTER TABLE email ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+-- +goose Down
+ALTER TABLE email DROP COLUMN size;
+ALTER TABLE email DROP COLUMN internal_date;
+ALTER TABLE email DROP COLUMN in_reply_to;
+ALTER TABLE email DROP COLUMN message_id;
+ALTER TABLE email DROP COLUMN bcc_addrs;
+ALTER TABLE email DROP COLUMN cc_addrs;
+ALTER TABLE email DROP COLUMN to_addrs;
+ALTER TABLE email DROP COLUMN reply_to;
+ALTER TABLE email DROP COLUMN sender_addr;
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE email ADD COLUMN flags TEXT NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN modseq INTEGER NOT NULL DEFAULT 0;
+-- +goose Down
+ALTER TABLE email DROP COLUMN modseq;
+ALTER TABLE email DROP COLUMN flags;
@@ -0,0 +1,4 @@
+-- +goose Up
+ALTER TABLE category ADD COLUMN subscribed BOOLEAN NOT NULL DEFAULT 0;
+-- +goose Down
+ALTER TABLE category DROP COLUMN subscribed;
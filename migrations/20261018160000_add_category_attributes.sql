@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE category ADD COLUMN attributes TEXT NOT NULL DEFAULT '';
+ALTER TABLE category ADD COLUMN role TEXT NOT NULL DEFAULT '';
+-- +goose Down
+ALTER TABLE category DROP COLUMN role;
+ALTER TABLE category DROP COLUMN attributes;
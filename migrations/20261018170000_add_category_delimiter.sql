@@ -0,0 +1,4 @@
+-- +goose Up
+ALTER TABLE category ADD COLUMN delimiter TEXT NOT NULL DEFAULT '';
+-- +goose Down
+ALTER TABLE category DROP COLUMN delimiter;
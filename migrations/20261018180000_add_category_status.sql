@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE category ADD COLUMN messages INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE category ADD COLUMN unseen INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE category ADD COLUMN uidnext INTEGER NOT NULL DEFAULT 0;
+-- +goose Down
+ALTER TABLE category DROP COLUMN uidnext;
+ALTER TABLE category DROP COLUMN unseen;
+ALTER TABLE category DROP COLUMN messages;
@@ -0,0 +1,8 @@
+// Package migrations embeds the goose SQL migrations so the binary can bring
+// its database up to date on its own.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
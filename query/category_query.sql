@@ -1,5 +1,26 @@
 -- name: CreateCategory :one
-INSERT INTO category (name, key, modseq) VALUES (?, ?, ?) RETURNING *;
+INSERT INTO category (name, key, modseq, uidvalidity) VALUES (?, ?, ?, ?) RETURNING *;
 
 -- name: GetCategory :one
 SELECT * FROM category WHERE key = ? LIMIT 1;
+
+-- name: UpdateCategorySyncState :exec
+UPDATE category SET modseq = ?, uidvalidity = ? WHERE id = ?;
+
+-- name: ListCategories :many
+SELECT * FROM category ORDER BY key;
+
+-- name: RenameCategory :exec
+UPDATE category SET name = ?, key = ? WHERE id = ?;
+
+-- name: DeleteCategory :exec
+DELETE FROM category WHERE id = ?;
+
+-- name: SetCategorySubscribed :exec
+UPDATE category SET subscribed = ? WHERE key = ?;
+
+-- name: UpdateCategoryAttributes :exec
+UPDATE category SET attributes = ?, role = ?, delimiter = ? WHERE key = ?;
+
+-- name: UpdateCategoryStatus :exec
+UPDATE category SET messages = ?, unseen = ?, uidnext = ? WHERE id = ?;
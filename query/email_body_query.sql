@@ -0,0 +1,6 @@
+-- name: GetEmailBody :one
+SELECT * FROM email_body WHERE email_id = ? LIMIT 1;
+
+-- name: SaveEmailBody :exec
+INSERT INTO email_body (email_id, body) VALUES (?, ?)
+ON CONFLICT (email_id) DO UPDATE SET body = excluded.body, fetched_at = CURRENT_TIMESTAMP;
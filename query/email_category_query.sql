@@ -1,2 +1,5 @@
 -- name: RegisterEmailAndCategory :exec
 INSERT INTO email_category (email_id, category_id) VALUES (?, ?);
+
+-- name: MoveEmailToCategory :exec
+UPDATE email_category SET category_id = ? WHERE email_id = ? AND category_id = ?;
@@ -2,4 +2,52 @@
 SELECT * FROM email WHERE id = ? LIMIT 1;
 
 -- name: CreateEmail :one
-INSERT INTO email (seq, sender, subject, email_date) VALUES (?, ?, ?, ?) RETURNING *;
+INSERT INTO email (
+  uid, uidvalidity, sender, subject, email_date, body_structure,
+  sender_addr, reply_to, to_addrs, cc_addrs, bcc_addrs, message_id, in_reply_to, internal_date, size,
+  flags, modseq
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: GetEmailByUID :one
+SELECT email.* FROM email
+JOIN email_category ON email_category.email_id = email.id
+WHERE email_category.category_id = ? AND email.uidvalidity = ? AND email.uid = ? LIMIT 1;
+
+-- name: ListEmailsByCategory :many
+SELECT email.* FROM email
+JOIN email_category ON email_category.email_id = email.id
+WHERE email_category.category_id = ?
+ORDER BY email.uid DESC;
+
+-- name: ListEmailUIDsByCategory :many
+SELECT email.uid FROM email
+JOIN email_category ON email_category.email_id = email.id
+WHERE email_category.category_id = ?
+ORDER BY email.uid;
+
+-- name: UpdateEmail :one
+UPDATE email SET
+  sender = ?, subject = ?, email_date = ?, body_structure = ?,
+  sender_addr = ?, reply_to = ?, to_addrs = ?, cc_addrs = ?, bcc_addrs = ?,
+  message_id = ?, in_reply_to = ?, internal_date = ?, size = ?,
+  flags = ?, modseq = ?
+WHERE id = ? RETURNING *;
+
+-- name: DeleteEmailByUID :exec
+DELETE FROM email
+WHERE uid = ? AND id IN (SELECT email_id FROM email_category WHERE category_id = ?);
+
+-- name: PurgeStaleEmails :exec
+DELETE FROM email
+WHERE uidvalidity != ? AND id IN (SELECT email_id FROM email_category WHERE category_id = ?);
+
+-- name: UpdateEmailFlags :exec
+UPDATE email SET flags = ?, modseq = ?
+WHERE uid = ? AND id IN (SELECT email_id FROM email_category WHERE category_id = ?);
+
+-- name: DeleteCategoryEmails :exec
+DELETE FROM email
+WHERE id IN (SELECT email_id FROM email_category WHERE category_id = ?);
+
+-- name: UpdateEmailUID :exec
+UPDATE email SET uid = ?, uidvalidity = ? WHERE id = ?;